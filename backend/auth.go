@@ -3,47 +3,80 @@ package main
 import (
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// Claims is the JWT payload.
+// Claims is the JWT payload. Scopes is empty on ordinary account tokens
+// (registerHandler/loginHandler), which carry full access to the account's
+// own games as before; a non-empty Scopes restricts the token to exactly the
+// listed capabilities, e.g. ["game:123:play", "game:123:hint"], the way a
+// spectator or invite link would.
 type Claims struct {
-	UserID   int    `json:"user_id"`
-	Username string `json:"username"`
+	UserID   int      `json:"user_id"`
+	Username string   `json:"username"`
+	Scopes   []string `json:"scopes,omitempty"`
+	Admin    bool     `json:"admin,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func jwtSecret() []byte {
-	s := os.Getenv("JWT_SECRET")
-	if s == "" {
-		panic("JWT_SECRET env var is not set")
+// hasScope reports whether c carries scope exactly.
+func (c *Claims) hasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
 	}
-	return []byte(s)
+	return false
 }
 
+// accessTokenTTL is intentionally short — refresh.go issues a long-lived
+// refresh token alongside every access token so clients can silently renew.
+const accessTokenTTL = 15 * time.Minute
+
 func signToken(userID int, username string) (string, error) {
+	return signScopedToken(userID, username, nil, false)
+}
+
+// signScopedToken mints an access token restricted to scopes. A nil or empty
+// scopes list yields an ordinary full-access account token. Capability
+// tokens minted for game sharing (see startGame, createBotGameHandler) always pass
+// admin=false — scoping never grants admin rights.
+func signScopedToken(userID int, username string, scopes []string, admin bool) (string, error) {
+	sk, err := globalKeyring.signingKey()
+	if err != nil {
+		return "", err
+	}
 	claims := Claims{
 		UserID:   userID,
 		Username: username,
+		Scopes:   scopes,
+		Admin:    admin,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
-	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret())
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = sk.kid
+	return token.SignedString(sk.priv)
 }
 
 func parseToken(tokenStr string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(t *jwt.Token) (any, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+		if _, ok := t.Method.(*jwt.SigningMethodEd25519); !ok {
 			return nil, jwt.ErrSignatureInvalid
 		}
-		return jwtSecret(), nil
+		kid, _ := t.Header["kid"].(string)
+		sk, ok := globalKeyring.verifyKey(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return sk.pub, nil
 	})
 	if err != nil {
 		return nil, err
@@ -56,7 +89,7 @@ func parseToken(tokenStr string) (*Claims, error) {
 }
 
 func registerHandler(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+	return withConstantLatency(func(w http.ResponseWriter, r *http.Request) {
 		var body struct {
 			Username string `json:"username"`
 			Password string `json:"password"`
@@ -82,18 +115,18 @@ func registerHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		token, err := signToken(id, body.Username)
+		accessToken, refreshToken, err := issueSession(db, id, body.Username)
 		if err != nil {
 			jsonError(w, "internal error", http.StatusInternalServerError)
 			return
 		}
 
-		writeJSON(w, http.StatusCreated, map[string]string{"token": token})
-	}
+		writeJSON(w, http.StatusCreated, map[string]string{"token": accessToken, "refresh_token": refreshToken})
+	})
 }
 
 func loginHandler(db *sql.DB) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+	return withConstantLatency(func(w http.ResponseWriter, r *http.Request) {
 		var body struct {
 			Username string `json:"username"`
 			Password string `json:"password"`
@@ -103,6 +136,14 @@ func loginHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		// Lockouts still return the generic credentials error, not a 429 —
+		// leaking "you're rate-limited" would itself confirm the username.
+		key := throttleKey(body.Username, clientIP(r))
+		if globalLoginThrottle.locked(key) {
+			jsonError(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+
 		var id int
 		var hash string
 		err := db.QueryRow(
@@ -111,6 +152,7 @@ func loginHandler(db *sql.DB) http.HandlerFunc {
 		).Scan(&id, &hash)
 		if err == sql.ErrNoRows || bcrypt.CompareHashAndPassword([]byte(hash), []byte(body.Password)) != nil {
 			// Deliberately identical response â€” prevents username enumeration.
+			globalLoginThrottle.recordFailure(key)
 			jsonError(w, "invalid credentials", http.StatusUnauthorized)
 			return
 		}
@@ -118,13 +160,14 @@ func loginHandler(db *sql.DB) http.HandlerFunc {
 			jsonError(w, "internal error", http.StatusInternalServerError)
 			return
 		}
+		globalLoginThrottle.reset(key)
 
-		token, err := signToken(id, body.Username)
+		accessToken, refreshToken, err := issueSession(db, id, body.Username)
 		if err != nil {
 			jsonError(w, "internal error", http.StatusInternalServerError)
 			return
 		}
 
-		writeJSON(w, http.StatusOK, map[string]string{"token": token})
-	}
+		writeJSON(w, http.StatusOK, map[string]string{"token": accessToken, "refresh_token": refreshToken})
+	})
 }
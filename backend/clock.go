@@ -0,0 +1,73 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// clockSweepInterval is how often startClockSweeper checks active games for
+// a player who has run out of time without anyone submitting a move to
+// trigger the check in moveHandler.
+const clockSweepInterval = 5 * time.Second
+
+// startClockSweeper runs sweepClocks on clockSweepInterval for the lifetime
+// of the process.
+func startClockSweeper(db *sql.DB) {
+	go func() {
+		for {
+			time.Sleep(clockSweepInterval)
+			if err := sweepClocks(db); err != nil {
+				log.Printf("clock sweeper: %v", err)
+			}
+		}
+	}()
+}
+
+// sweepClocks finds active games whose side to move has flagged and marks
+// them finished, publishing a game_state event the same way moveHandler
+// does when it catches a timeout on a move attempt.
+func sweepClocks(db *sql.DB) error {
+	rows, err := db.Query(`SELECT id, current_fen, white_time_ms, black_time_ms, last_move_at FROM games WHERE status = 'active'`)
+	if err != nil {
+		return err
+	}
+
+	type candidate struct {
+		id                       int
+		fen                      string
+		whiteTimeMS, blackTimeMS int64
+		lastMoveAt               time.Time
+	}
+	var flagged []int
+	for rows.Next() {
+		var c candidate
+		if err := rows.Scan(&c.id, &c.fen, &c.whiteTimeMS, &c.blackTimeMS, &c.lastMoveAt); err != nil {
+			continue
+		}
+		color, err := activeColor(c.fen)
+		if err != nil {
+			continue
+		}
+		moverTimeMS := c.whiteTimeMS
+		if color == 'b' {
+			moverTimeMS = c.blackTimeMS
+		}
+		if time.Since(c.lastMoveAt).Milliseconds() >= moverTimeMS {
+			flagged = append(flagged, c.id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range flagged {
+		res, err := db.Exec(`UPDATE games SET status = 'finished', game_state = 'TIMEOUT' WHERE id = $1 AND status = 'active'`, id)
+		if err != nil {
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		globalGameHub.publish(id, gameEvent{Type: "game_state", Data: map[string]string{"status": "finished", "game_state": "TIMEOUT"}})
+	}
+	return nil
+}
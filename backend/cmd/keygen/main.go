@@ -0,0 +1,55 @@
+// Command keygen writes a new Ed25519 signing keypair into a JWT_KEYS_DIR
+// directory, PKCS#8 private key and PKIX public key, both PEM-encoded.
+// Drop the output into the running server's key directory and send it a
+// SIGHUP to start using the new key.
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func main() {
+	dir := flag.String("dir", "./keys", "directory to write the new keypair into")
+	flag.Parse()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatalf("generate key: %v", err)
+	}
+
+	privBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		log.Fatalf("marshal private key: %v", err)
+	}
+	pubBytes, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		log.Fatalf("marshal public key: %v", err)
+	}
+
+	if err := os.MkdirAll(*dir, 0o700); err != nil {
+		log.Fatalf("mkdir %s: %v", *dir, err)
+	}
+
+	// Date-stamped so filenames sort in creation order — the newest key
+	// becomes the signing key on load.
+	name := time.Now().UTC().Format("20060102T150405")
+	privPath := filepath.Join(*dir, name+".pem")
+	pubPath := filepath.Join(*dir, name+".pub.pem")
+
+	if err := os.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}), 0o600); err != nil {
+		log.Fatalf("write private key: %v", err)
+	}
+	if err := os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes}), 0o644); err != nil {
+		log.Fatalf("write public key: %v", err)
+	}
+
+	log.Printf("wrote new Ed25519 keypair: %s (private), %s (public)", privPath, pubPath)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"database/sql"
 	"log"
+	"os"
 
 	_ "github.com/lib/pq"
 )
@@ -20,7 +21,8 @@ func initDB(dsn string) *sql.DB {
 		CREATE TABLE IF NOT EXISTS users (
 			id            SERIAL PRIMARY KEY,
 			username      TEXT UNIQUE NOT NULL,
-			password_hash TEXT NOT NULL
+			password_hash TEXT NOT NULL,
+			is_admin      BOOLEAN NOT NULL DEFAULT false
 		);
 
 		CREATE TABLE IF NOT EXISTS games (
@@ -31,7 +33,15 @@ func initDB(dsn string) *sql.DB {
 			status      TEXT NOT NULL DEFAULT 'active',
 			white_hints INTEGER NOT NULL DEFAULT 3,
 			black_hints INTEGER NOT NULL DEFAULT 3,
-			bot_depth   INTEGER NOT NULL DEFAULT 0
+			bot_depth   INTEGER NOT NULL DEFAULT 0,
+			initial_seconds   INTEGER NOT NULL DEFAULT 600,
+			increment_seconds INTEGER NOT NULL DEFAULT 0,
+			white_time_ms     BIGINT NOT NULL DEFAULT 600000,
+			black_time_ms     BIGINT NOT NULL DEFAULT 600000,
+			last_move_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+			created_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+			game_state        TEXT NOT NULL DEFAULT '',
+			eco_code          TEXT NOT NULL DEFAULT ''
 		);
 
 		CREATE TABLE IF NOT EXISTS moves (
@@ -42,6 +52,50 @@ func initDB(dsn string) *sql.DB {
 			fen_after TEXT NOT NULL,     -- board state after this move
 			UNIQUE (game_id, ply)
 		);
+
+		CREATE TABLE IF NOT EXISTS queue_entries (
+			user_id      INTEGER PRIMARY KEY REFERENCES users(id),
+			time_control TEXT NOT NULL DEFAULT 'unrated',
+			created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
+		CREATE TABLE IF NOT EXISTS challenges (
+			id         SERIAL PRIMARY KEY,
+			from_id    INTEGER NOT NULL REFERENCES users(id),
+			to_id      INTEGER NOT NULL REFERENCES users(id),
+			status     TEXT NOT NULL DEFAULT 'pending', -- pending, accepted, declined
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
+		CREATE TABLE IF NOT EXISTS messages (
+			id         SERIAL PRIMARY KEY,
+			game_id    INTEGER NOT NULL REFERENCES games(id),
+			sender_id  INTEGER NOT NULL REFERENCES users(id),
+			body       TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
+		CREATE TABLE IF NOT EXISTS user_identities (
+			id       SERIAL PRIMARY KEY,
+			user_id  INTEGER NOT NULL REFERENCES users(id),
+			provider TEXT NOT NULL,
+			subject  TEXT NOT NULL,
+			email    TEXT,
+			UNIQUE (provider, subject)
+		);
+
+		CREATE TABLE IF NOT EXISTS refresh_tokens (
+			id          SERIAL PRIMARY KEY,
+			user_id     INTEGER NOT NULL REFERENCES users(id),
+			token_hash  TEXT NOT NULL UNIQUE,
+			expires_at  TIMESTAMPTZ NOT NULL,
+			revoked_at  TIMESTAMPTZ,
+			replaced_by INTEGER REFERENCES refresh_tokens(id)
+		);
+
+		CREATE TABLE IF NOT EXISTS engine_backends (
+			url TEXT PRIMARY KEY
+		);
 	`)
 	if err != nil {
 		log.Fatalf("db migrate: %v", err)
@@ -59,5 +113,41 @@ func initDB(dsn string) *sql.DB {
 		log.Fatalf("db migrate bot_depth: %v", err)
 	}
 
+	_, err = db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT false`)
+	if err != nil {
+		log.Fatalf("db migrate is_admin: %v", err)
+	}
+
+	// Grant admin to the operator-designated account, if any. Idempotent so
+	// it's safe to run on every startup; without this there is no way to
+	// reach the admin-gated routes (POST /admin/unlock, POST /game,
+	// POST /games/import, GET /metrics) on a fresh deployment.
+	if admin := os.Getenv("ADMIN_USERNAME"); admin != "" {
+		_, err = db.Exec(`UPDATE users SET is_admin = true WHERE username = $1`, admin)
+		if err != nil {
+			log.Fatalf("db seed admin: %v", err)
+		}
+	}
+
+	_, err = db.Exec(`
+		ALTER TABLE games ADD COLUMN IF NOT EXISTS initial_seconds INTEGER NOT NULL DEFAULT 600;
+		ALTER TABLE games ADD COLUMN IF NOT EXISTS increment_seconds INTEGER NOT NULL DEFAULT 0;
+		ALTER TABLE games ADD COLUMN IF NOT EXISTS white_time_ms BIGINT NOT NULL DEFAULT 600000;
+		ALTER TABLE games ADD COLUMN IF NOT EXISTS black_time_ms BIGINT NOT NULL DEFAULT 600000;
+		ALTER TABLE games ADD COLUMN IF NOT EXISTS last_move_at TIMESTAMPTZ NOT NULL DEFAULT now();
+	`)
+	if err != nil {
+		log.Fatalf("db migrate clocks: %v", err)
+	}
+
+	_, err = db.Exec(`
+		ALTER TABLE games ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+		ALTER TABLE games ADD COLUMN IF NOT EXISTS game_state TEXT NOT NULL DEFAULT '';
+		ALTER TABLE games ADD COLUMN IF NOT EXISTS eco_code TEXT NOT NULL DEFAULT '';
+	`)
+	if err != nil {
+		log.Fatalf("db migrate pgn: %v", err)
+	}
+
 	return db
 }
@@ -0,0 +1,99 @@
+package main
+
+import "database/sql"
+
+// ecoBookPlies is how deep into the game an opening is still worth
+// classifying — past this, two games that started the same way have long
+// since diverged from any named book line.
+const ecoBookPlies = 12
+
+// ecoEntry is one line of the embedded opening book, keyed by its UCI move
+// prefix from the starting position.
+type ecoEntry struct {
+	Moves []string
+	Code  string
+	Name  string
+}
+
+// ecoTable is a small embedded opening book — far from exhaustive, but wide
+// enough to tag the openings a casual server's games actually reach.
+var ecoTable = []ecoEntry{
+	{[]string{"e2e4"}, "C20", "King's Pawn Game"},
+	{[]string{"e2e4", "e7e5"}, "C20", "King's Pawn Game"},
+	{[]string{"e2e4", "e7e5", "g1f3"}, "C40", "King's Knight Opening"},
+	{[]string{"e2e4", "e7e5", "g1f3", "b8c6"}, "C44", "Open Game"},
+	{[]string{"e2e4", "e7e5", "g1f3", "b8c6", "f1b5"}, "C60", "Ruy Lopez"},
+	{[]string{"e2e4", "e7e5", "g1f3", "b8c6", "f1c4"}, "C50", "Italian Game"},
+	{[]string{"e2e4", "e7e5", "f2f4"}, "C30", "King's Gambit"},
+	{[]string{"e2e4", "c7c5"}, "B20", "Sicilian Defence"},
+	{[]string{"e2e4", "c7c5", "g1f3"}, "B27", "Sicilian Defence"},
+	{[]string{"e2e4", "c7c5", "g1f3", "d7d6"}, "B50", "Sicilian Defence"},
+	{[]string{"e2e4", "e7e6"}, "C00", "French Defence"},
+	{[]string{"e2e4", "c7c6"}, "B10", "Caro-Kann Defence"},
+	{[]string{"e2e4", "d7d5"}, "B01", "Scandinavian Defence"},
+	{[]string{"e2e4", "g8f6"}, "B00", "Alekhine's Defence"},
+	{[]string{"d2d4", "d7d5"}, "D00", "Queen's Pawn Game"},
+	{[]string{"d2d4", "d7d5", "c2c4"}, "D06", "Queen's Gambit"},
+	{[]string{"d2d4", "d7d5", "c2c4", "e7e6"}, "D30", "Queen's Gambit Declined"},
+	{[]string{"d2d4", "d7d5", "c2c4", "c7c6"}, "D10", "Slav Defence"},
+	{[]string{"d2d4", "g8f6"}, "A45", "Indian Defence"},
+	{[]string{"d2d4", "g8f6", "c2c4", "g7g6"}, "E60", "King's Indian Defence"},
+	{[]string{"d2d4", "g8f6", "c2c4", "e7e6"}, "E00", "Catalan/Indian systems"},
+	{[]string{"d2d4", "f7f5"}, "A80", "Dutch Defence"},
+	{[]string{"c2c4"}, "A10", "English Opening"},
+	{[]string{"g1f3"}, "A04", "Reti Opening"},
+}
+
+// classifyECO returns the code and name of the longest book line that
+// matches a prefix of moves (the game's UCI moves from the start). Returns
+// "", "" if no book line matches at all.
+func classifyECO(moves []string) (code, name string) {
+	bestLen := 0
+	for _, entry := range ecoTable {
+		if len(entry.Moves) > len(moves) || len(entry.Moves) <= bestLen {
+			continue
+		}
+		match := true
+		for i, m := range entry.Moves {
+			if moves[i] != m {
+				match = false
+				break
+			}
+		}
+		if match {
+			bestLen = len(entry.Moves)
+			code, name = entry.Code, entry.Name
+		}
+	}
+	return code, name
+}
+
+// updateECO reclassifies the opening after a move and persists it, as long
+// as the game is still within book depth. Called from moveHandler and
+// fireBotMove right after a move commits; a no-op past ecoBookPlies.
+func updateECO(db *sql.DB, gameID, ply int) {
+	if ply > ecoBookPlies {
+		return
+	}
+
+	rows, err := db.Query(`SELECT uci FROM moves WHERE game_id = $1 ORDER BY ply`, gameID)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	var moves []string
+	for rows.Next() {
+		var uci string
+		if err := rows.Scan(&uci); err != nil {
+			return
+		}
+		moves = append(moves, uci)
+	}
+
+	code, _ := classifyECO(moves)
+	if code == "" {
+		return
+	}
+	_, _ = db.Exec(`UPDATE games SET eco_code = $1 WHERE id = $2`, code, gameID)
+}
@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// engineClient is a single keepalive pool shared by every backend in the
+// broker's pool, so the TCP connections stay reused no matter which backend
+// a given call round-robins to. Its Timeout is a last-resort backstop only —
+// every request carries its own context deadline (engineRequestTimeout for
+// quick calls, a caller-chosen one for searches) which fires first in normal
+// operation.
+var engineClient = &http.Client{
+	Transport: &http.Transport{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+	},
+	Timeout: 125 * time.Second,
+}
+
+// engineRequestTimeout bounds Move/LegalMoves/SAN calls, which should always
+// be fast — callers don't set their own deadline for these, so post() applies
+// this one when the incoming context carries none.
+const engineRequestTimeout = 10 * time.Second
+
+// fenCacheCapacity bounds the search cache — large enough to carry a busy
+// server's worth of repeat openings and hint spam without growing unbounded.
+const fenCacheCapacity = 2048
+
+// engineHealthcheckInterval is how often each backend's /health is polled.
+const engineHealthcheckInterval = 10 * time.Second
+
+// engineBackend is one C++ engine process in the pool.
+type engineBackend struct {
+	url     string
+	healthy atomic.Bool
+}
+
+// EngineBroker fans search/move/legal-move/SAN requests out across a pool of
+// C++ engine backends (round-robin, skipping unhealthy ones), coalesces
+// concurrent identical searches, caches search results by FEN+depth+movetime, and
+// cancels in-flight engine searches when the request that started them is
+// abandoned.
+type EngineBroker struct {
+	mu       sync.RWMutex
+	backends []*engineBackend
+	rr       atomic.Uint64
+
+	cache  *fenCache
+	flight *callGroup
+
+	metrics *engineMetrics
+}
+
+// newEngineBroker builds a broker over urls, assumed healthy until the first
+// healthcheck says otherwise. Falls back to the single pre-broker default if
+// urls is empty.
+func newEngineBroker(urls []string) *EngineBroker {
+	if len(urls) == 0 {
+		urls = []string{"http://localhost:8081"}
+	}
+	return &EngineBroker{
+		backends: newEngineBackends(urls),
+		cache:    newFENCache(fenCacheCapacity),
+		flight:   &callGroup{calls: map[string]*inflightCall{}},
+		metrics:  newEngineMetrics(),
+	}
+}
+
+func newEngineBackends(urls []string) []*engineBackend {
+	backends := make([]*engineBackend, len(urls))
+	for i, u := range urls {
+		b := &engineBackend{url: strings.TrimRight(u, "/")}
+		b.healthy.Store(true)
+		backends[i] = b
+	}
+	return backends
+}
+
+// setBackends atomically replaces the pool, e.g. after a service-registry
+// refresh picks up scaled-up or retired engine instances.
+func (b *EngineBroker) setBackends(urls []string) {
+	backends := newEngineBackends(urls)
+	b.mu.Lock()
+	b.backends = backends
+	b.mu.Unlock()
+}
+
+// pick round-robins across healthy backends. Falls back to an unhealthy one
+// rather than failing outright if every backend is currently down.
+func (b *EngineBroker) pick() *engineBackend {
+	b.mu.RLock()
+	backends := b.backends
+	b.mu.RUnlock()
+	if len(backends) == 0 {
+		return nil
+	}
+
+	start := b.rr.Add(1)
+	for i := 0; i < len(backends); i++ {
+		if be := backends[(start+uint64(i))%uint64(len(backends))]; be.healthy.Load() {
+			return be
+		}
+	}
+	return backends[start%uint64(len(backends))]
+}
+
+// startHealthchecks polls every backend's /health on engineHealthcheckInterval
+// for the lifetime of the process.
+func (b *EngineBroker) startHealthchecks() {
+	go func() {
+		for {
+			b.checkHealth()
+			time.Sleep(engineHealthcheckInterval)
+		}
+	}()
+}
+
+func (b *EngineBroker) checkHealth() {
+	b.mu.RLock()
+	backends := b.backends
+	b.mu.RUnlock()
+
+	for _, be := range backends {
+		go func(be *engineBackend) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, be.url+"/health", nil)
+			if err != nil {
+				be.healthy.Store(false)
+				return
+			}
+			resp, err := engineClient.Do(req)
+			if err != nil {
+				be.healthy.Store(false)
+				return
+			}
+			resp.Body.Close()
+			be.healthy.Store(resp.StatusCode == http.StatusOK)
+		}(be)
+	}
+}
+
+// post sends payload to path on a round-robined backend and decodes the
+// response into out, returning the HTTP status code so callers can
+// distinguish a well-formed engine error from a transport failure.
+func (b *EngineBroker) post(ctx context.Context, path string, payload any, out any) (statusCode int, err error) {
+	backend := b.pick()
+	if backend == nil {
+		return 0, fmt.Errorf("no engine backends configured")
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, engineRequestTimeout)
+		defer cancel()
+	}
+
+	body, _ := json.Marshal(payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, backend.url+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := engineClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return resp.StatusCode, err
+	}
+	return resp.StatusCode, nil
+}
+
+// Move validates and applies uciMove against fen.
+func (b *EngineBroker) Move(ctx context.Context, fen, uciMove string) (status, gameState, newFEN string, err error) {
+	var resp struct {
+		Status    string `json:"status"`
+		GameState string `json:"game_state"`
+		NewFEN    string `json:"new_fen"`
+		Error     string `json:"error"`
+	}
+	code, err := b.post(ctx, "/move", map[string]string{"fen": fen, "uci_move": uciMove}, &resp)
+	if err != nil {
+		return "", "", "", err
+	}
+	if code != http.StatusOK {
+		return "", "", "", fmt.Errorf("engine error: %s", resp.Error)
+	}
+	return resp.Status, resp.GameState, resp.NewFEN, nil
+}
+
+// LegalMoves returns every legal move in fen, in UCI form.
+func (b *EngineBroker) LegalMoves(ctx context.Context, fen string) ([]string, error) {
+	var resp struct {
+		Moves []string `json:"moves"`
+		Error string   `json:"error"`
+	}
+	code, err := b.post(ctx, "/legal_moves", map[string]string{"fen": fen}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if code != http.StatusOK {
+		return nil, fmt.Errorf("engine error: %s", resp.Error)
+	}
+	return resp.Moves, nil
+}
+
+// SAN renders uciMove as algebraic notation in the context of fen.
+func (b *EngineBroker) SAN(ctx context.Context, fen, uciMove string) (string, error) {
+	var resp struct {
+		SAN   string `json:"san"`
+		Error string `json:"error"`
+	}
+	code, err := b.post(ctx, "/san", map[string]string{"fen": fen, "uci_move": uciMove}, &resp)
+	if err != nil {
+		return "", err
+	}
+	if code != http.StatusOK {
+		return "", fmt.Errorf("engine error: %s", resp.Error)
+	}
+	return resp.SAN, nil
+}
+
+// fenCacheEntry is what Search caches per FEN+depth+movetime.
+type fenCacheEntry struct {
+	bestMove string
+	score    int
+}
+
+// Search returns the engine's best move and score for fen at depth, capped
+// at movetimeMS of thinking time (0 = no cap). Repeat fen+depth+movetimeMS
+// combinations short-circuit via the FEN cache; concurrent identical
+// requests share one engine call.
+func (b *EngineBroker) Search(ctx context.Context, fen string, depth int, movetimeMS int64) (bestMove string, score int, err error) {
+	key := fmt.Sprintf("%s|%d|%d", fen, depth, movetimeMS)
+
+	if entry, ok := b.cache.get(key); ok {
+		b.metrics.cacheHits.Add(1)
+		return entry.bestMove, entry.score, nil
+	}
+	b.metrics.cacheMisses.Add(1)
+
+	b.metrics.inFlight.Add(1)
+	defer b.metrics.inFlight.Add(-1)
+
+	// The cache is populated from inside the coalesced call, before callGroup
+	// releases the key — otherwise a caller arriving right as the leader
+	// finishes could see neither an inflight call nor a cache entry and fire
+	// a redundant search.
+	v, err := b.flight.do(key, func() (any, error) {
+		entry, err := b.search(ctx, fen, depth, movetimeMS)
+		if err != nil {
+			return nil, err
+		}
+		b.cache.put(key, entry.(fenCacheEntry))
+		return entry, nil
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	entry := v.(fenCacheEntry)
+	return entry.bestMove, entry.score, nil
+}
+
+// search performs the actual uncached engine call, cancelling the engine's
+// search via /cancel if ctx ends before it responds. Coalesced callers share
+// the context of whichever caller's request started the search group — only
+// that first caller's disconnect can cut the shared work short.
+func (b *EngineBroker) search(ctx context.Context, fen string, depth int, movetimeMS int64) (any, error) {
+	backend := b.pick()
+	if backend == nil {
+		return nil, fmt.Errorf("no engine backends configured")
+	}
+
+	token, err := newSearchToken()
+	if err != nil {
+		return nil, err
+	}
+
+	payload, _ := json.Marshal(map[string]any{
+		"fen": fen, "depth": depth, "movetime_ms": movetimeMS, "token": token,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, backend.url+"/search", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	resp, err := engineClient.Do(req)
+	b.metrics.recordLatency(time.Since(start))
+	if err != nil {
+		if ctx.Err() != nil {
+			go b.cancelSearch(backend.url, token)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var searchResp struct {
+		BestMove string `json:"best_move"`
+		Score    int    `json:"score"`
+		Error    string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&searchResp); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("engine error: %s", searchResp.Error)
+	}
+	return fenCacheEntry{bestMove: searchResp.BestMove, score: searchResp.Score}, nil
+}
+
+// cancelSearch tells backendURL to abandon the search running under token.
+// Best-effort and fire-and-forget, on its own short-lived context since the
+// request that started the search is already gone.
+func (b *EngineBroker) cancelSearch(backendURL, token string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, backendURL+"/cancel?token="+token, nil)
+	if err != nil {
+		return
+	}
+	resp, err := engineClient.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
+
+func newSearchToken() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// fenCache is a small LRU cache from a "fen|depth|movetimeMS" key to the engine's last
+// answer for that position, so hint spam and repeated opening moves don't
+// all round-trip to the engine.
+type fenCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type fenCacheNode struct {
+	key   string
+	value fenCacheEntry
+}
+
+func newFENCache(capacity int) *fenCache {
+	return &fenCache{capacity: capacity, ll: list.New(), items: map[string]*list.Element{}}
+}
+
+func (c *fenCache) get(key string) (fenCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return fenCacheEntry{}, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*fenCacheNode).value, true
+}
+
+func (c *fenCache) put(key string, value fenCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*fenCacheNode).value = value
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&fenCacheNode{key: key, value: value})
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		if oldest == nil {
+			return
+		}
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*fenCacheNode).key)
+	}
+}
+
+// inflightCall is one in-progress (or just-finished) call tracked by a
+// callGroup.
+type inflightCall struct {
+	wg  sync.WaitGroup
+	val any
+	err error
+}
+
+// callGroup coalesces concurrent calls sharing a key into a single
+// underlying call — the singleflight pattern, hand-rolled here since the
+// broker is the only place that needs it.
+type callGroup struct {
+	mu    sync.Mutex
+	calls map[string]*inflightCall
+}
+
+func (g *callGroup) do(key string, fn func() (any, error)) (any, error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err
+	}
+	c := &inflightCall{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.val, c.err = fn()
+	c.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+
+	return c.val, c.err
+}
+
+// engineMetrics tracks the broker's cache effectiveness, queue depth, and
+// search latency distribution, surfaced on GET /metrics.
+type engineMetrics struct {
+	cacheHits   atomic.Int64
+	cacheMisses atomic.Int64
+	inFlight    atomic.Int64
+
+	mu      sync.Mutex
+	buckets []int64
+}
+
+// latencyBucketBoundsMS are the upper bounds (inclusive) of the search
+// latency histogram's buckets; the final bucket catches anything slower.
+var latencyBucketBoundsMS = []int64{50, 100, 250, 500, 1000, 2000, 5000, 10000}
+
+func newEngineMetrics() *engineMetrics {
+	return &engineMetrics{buckets: make([]int64, len(latencyBucketBoundsMS)+1)}
+}
+
+func (m *engineMetrics) recordLatency(d time.Duration) {
+	ms := d.Milliseconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, bound := range latencyBucketBoundsMS {
+		if ms <= bound {
+			m.buckets[i]++
+			return
+		}
+	}
+	m.buckets[len(latencyBucketBoundsMS)]++
+}
+
+func (m *engineMetrics) snapshot() map[string]any {
+	hits, misses := m.cacheHits.Load(), m.cacheMisses.Load()
+	var hitRatio float64
+	if total := hits + misses; total > 0 {
+		hitRatio = float64(hits) / float64(total)
+	}
+
+	m.mu.Lock()
+	histogram := make(map[string]int64, len(latencyBucketBoundsMS)+1)
+	for i, bound := range latencyBucketBoundsMS {
+		histogram[fmt.Sprintf("<=%dms", bound)] = m.buckets[i]
+	}
+	histogram[">10000ms"] = m.buckets[len(latencyBucketBoundsMS)]
+	m.mu.Unlock()
+
+	return map[string]any{
+		"queue_depth":                 m.inFlight.Load(),
+		"cache_hits":                  hits,
+		"cache_misses":                misses,
+		"cache_hit_ratio":             hitRatio,
+		"search_latency_ms_histogram": histogram,
+	}
+}
+
+// metricsHandler exposes the engine broker's metrics as JSON.
+// GET /metrics
+func metricsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, globalEngineBroker.metrics.snapshot())
+	}
+}
+
+// engineURLsFromEnv parses ENGINE_URLS ("http://a:8081,http://b:8081") into a
+// backend list. Falls back to the single-engine ENGINE_URL/localhost default
+// used before the broker existed, so single-engine deployments need no
+// config changes.
+func engineURLsFromEnv() []string {
+	raw := os.Getenv("ENGINE_URLS")
+	if raw == "" {
+		if single := os.Getenv("ENGINE_URL"); single != "" {
+			return []string{single}
+		}
+		return []string{"http://localhost:8081"}
+	}
+
+	var urls []string
+	for _, u := range strings.Split(raw, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// loadEngineRegistry reads backend URLs from the engine_backends table, for
+// deployments that manage the pool as data — an orchestrator registering and
+// retiring engine instances — instead of via the static ENGINE_URLS env var.
+func loadEngineRegistry(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`SELECT url FROM engine_backends ORDER BY url`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var urls []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
+
+// globalEngineBroker is the process-wide engine pool, sized from
+// ENGINE_URLS/ENGINE_URL at startup; main() may replace it with the
+// engine_backends table's list once the DB is up.
+var globalEngineBroker = newEngineBroker(engineURLsFromEnv())
@@ -1,12 +1,11 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -21,6 +20,13 @@ var botDepthByDifficulty = map[int]int{
 	4: 8, // Master      — strong, slow
 }
 
+// Time control applied when a game is started without one of its own
+// (direct admin pairing, challenges — neither carries a time control yet).
+const (
+	defaultInitialSeconds   = 600
+	defaultIncrementSeconds = 0
+)
+
 // Game is the full game row joined with player usernames.
 type Game struct {
 	ID            int    `json:"id"`
@@ -134,25 +140,6 @@ func myGamesHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-// engineClient is a package-level client so the underlying TCP connection pool
-// is reused across all requests (keep-alive).
-var engineClient = &http.Client{
-	Transport: &http.Transport{
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 100,
-		IdleConnTimeout:     90 * time.Second,
-		DisableKeepAlives:   false,
-	},
-	Timeout: 10 * time.Second,
-}
-
-func engineURL() string {
-	if u := os.Getenv("ENGINE_URL"); u != "" {
-		return u
-	}
-	return "http://localhost:8081"
-}
-
 // activeColor returns 'w' or 'b' from the second field of a FEN string.
 func activeColor(fen string) (byte, error) {
 	parts := strings.Fields(fen)
@@ -166,10 +153,18 @@ func activeColor(fen string) (byte, error) {
 	return c, nil
 }
 
-// createGameHandler pairs two existing users into a new game.
+// createGameHandler directly pairs two existing users into a new game.
+// Restricted to admin use now that matchmaking.go gives regular players a
+// real way to form games: the queue (POST /queue) or a direct challenge
+// (POST /challenge, accepted via POST /challenge/{id}/accept).
 // POST /game  {"white_username":"alice","black_username":"bob"}
 func createGameHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if !claimsFromCtx(r).Admin {
+			jsonError(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
 		var body struct {
 			WhiteUsername string `json:"white_username"`
 			BlackUsername string `json:"black_username"`
@@ -200,20 +195,51 @@ func createGameHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		var gameID int
-		err = db.QueryRow(
-			`INSERT INTO games (white_id, black_id) VALUES ($1, $2) RETURNING id`,
-			whiteID, blackID,
-		).Scan(&gameID)
+		gameID, whiteToken, blackToken, err := startGame(db, whiteID, body.WhiteUsername, blackID, body.BlackUsername, defaultInitialSeconds, defaultIncrementSeconds)
 		if err != nil {
 			jsonError(w, "internal error", http.StatusInternalServerError)
 			return
 		}
 
-		writeJSON(w, http.StatusCreated, map[string]int{"game_id": gameID})
+		writeJSON(w, http.StatusCreated, map[string]any{
+			"game_id":     gameID,
+			"white_token": whiteToken,
+			"black_token": blackToken,
+		})
 	}
 }
 
+// startGame inserts a new active game row for the two players and mints
+// per-player capability tokens scoped to it (play + hint) — the same
+// capability tokens a spectator/invite link could hand out later without
+// granting access to the rest of either account. Shared by createGameHandler,
+// the matchmaker, and accepted challenges. Both clocks start at
+// initialSeconds; incrementSeconds is credited to the mover after each move.
+func startGame(db *sql.DB, whiteID int, whiteUsername string, blackID int, blackUsername string, initialSeconds, incrementSeconds int) (gameID int, whiteToken, blackToken string, err error) {
+	err = db.QueryRow(
+		`INSERT INTO games (white_id, black_id, initial_seconds, increment_seconds, white_time_ms, black_time_ms)
+		 VALUES ($1, $2, $3, $4, $5, $5) RETURNING id`,
+		whiteID, blackID, initialSeconds, incrementSeconds, int64(initialSeconds)*1000,
+	).Scan(&gameID)
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	scopes := []string{
+		fmt.Sprintf("game:%d:play", gameID),
+		fmt.Sprintf("game:%d:hint", gameID),
+	}
+	whiteToken, err = signScopedToken(whiteID, whiteUsername, scopes, false)
+	if err != nil {
+		return 0, "", "", err
+	}
+	blackToken, err = signScopedToken(blackID, blackUsername, scopes, false)
+	if err != nil {
+		return 0, "", "", err
+	}
+	return gameID, whiteToken, blackToken, nil
+}
+
 // createBotGameHandler starts a new game against the Engine bot.
 // POST /game/bot  {"difficulty": 1-4}
 func createBotGameHandler(db *sql.DB) http.HandlerFunc {
@@ -244,7 +270,16 @@ func createBotGameHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		writeJSON(w, http.StatusCreated, map[string]int{"game_id": gameID})
+		token, err := signScopedToken(claims.UserID, claims.Username, []string{
+			fmt.Sprintf("game:%d:play", gameID),
+			fmt.Sprintf("game:%d:hint", gameID),
+		}, false)
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, map[string]any{"game_id": gameID, "token": token})
 	}
 }
 
@@ -265,12 +300,17 @@ func moveHandler(db *sql.DB) http.HandlerFunc {
 		}
 
 		// Load game state.
-		var whiteID, blackID, botDepth int
+		var whiteID, blackID, botDepth, incrementSeconds int
 		var currentFEN, status string
+		var whiteTimeMS, blackTimeMS int64
+		var lastMoveAt time.Time
 		err := db.QueryRow(
-			`SELECT white_id, black_id, current_fen, status, bot_depth FROM games WHERE id = $1`,
+			`SELECT white_id, black_id, current_fen, status, bot_depth,
+			        increment_seconds, white_time_ms, black_time_ms, last_move_at
+			 FROM games WHERE id = $1`,
 			body.GameID,
-		).Scan(&whiteID, &blackID, &currentFEN, &status, &botDepth)
+		).Scan(&whiteID, &blackID, &currentFEN, &status, &botDepth,
+			&incrementSeconds, &whiteTimeMS, &blackTimeMS, &lastMoveAt)
 		if err == sql.ErrNoRows {
 			jsonError(w, "game not found", http.StatusNotFound)
 			return
@@ -299,41 +339,58 @@ func moveHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		// Forward to C++ engine over the keep-alive pool.
-		payload, _ := json.Marshal(map[string]string{
-			"fen":      currentFEN,
-			"uci_move": body.UCIMove,
-		})
-		resp, err := engineClient.Post(engineURL()+"/move", "application/json", bytes.NewReader(payload))
+		// The mover's clock keeps running until the move actually lands here,
+		// so check it before doing anything else — a flagged player can't save
+		// themselves by getting an illegal move rejected by the engine.
+		moverTimeMS := whiteTimeMS
+		if color == 'b' {
+			moverTimeMS = blackTimeMS
+		}
+		remainingMS := moverTimeMS - time.Since(lastMoveAt).Milliseconds()
+		if remainingMS <= 0 {
+			if _, err := db.Exec(`UPDATE games SET status = 'finished', game_state = 'TIMEOUT' WHERE id = $1`, body.GameID); err != nil {
+				jsonError(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			globalGameHub.publish(body.GameID, gameEvent{Type: "game_state", Data: map[string]string{"status": "finished", "game_state": "TIMEOUT"}})
+			writeJSON(w, http.StatusOK, map[string]string{"status": "TIMEOUT", "game_state": "TIMEOUT"})
+			return
+		}
+		remainingMS += int64(incrementSeconds) * 1000
+
+		// Fast pre-check against the engine's legal-move list: a 422 here
+		// skips the heavier /move round-trip for input that was never legal.
+		legalMoves, err := fetchLegalMoves(r.Context(), currentFEN)
 		if err != nil {
 			jsonError(w, "engine unreachable", http.StatusBadGateway)
 			return
 		}
-		defer resp.Body.Close()
-
-		var engineResp struct {
-			Status    string `json:"status"`
-			GameState string `json:"game_state"`
-			NewFEN    string `json:"new_fen"`
-			Error     string `json:"error"`
+		legal := false
+		for _, m := range legalMoves {
+			if m == body.UCIMove {
+				legal = true
+				break
+			}
 		}
-		if err := json.NewDecoder(resp.Body).Decode(&engineResp); err != nil {
-			jsonError(w, "invalid engine response", http.StatusBadGateway)
+		if !legal {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"status": "INVALID"})
 			return
 		}
-		if resp.StatusCode != http.StatusOK {
-			jsonError(w, "engine error: "+engineResp.Error, http.StatusBadGateway)
+
+		moveStatus, gameState, newFEN, err := globalEngineBroker.Move(r.Context(), currentFEN, body.UCIMove)
+		if err != nil {
+			jsonError(w, "engine unreachable", http.StatusBadGateway)
 			return
 		}
 
-		if engineResp.Status == "INVALID" {
+		if moveStatus == "INVALID" {
 			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"status": "INVALID"})
 			return
 		}
 
 		// Persist new FEN, status, and move record atomically.
 		newStatus := "active"
-		switch engineResp.GameState {
+		switch gameState {
 		case "CHECKMATE", "STALEMATE", "DRAW_50_MOVE", "DRAW_INSUFFICIENT":
 			newStatus = "finished"
 		}
@@ -354,9 +411,13 @@ func moveHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		clockCol := "white_time_ms"
+		if color == 'b' {
+			clockCol = "black_time_ms"
+		}
 		if _, err = tx.Exec(
-			`UPDATE games SET current_fen = $1, status = $2 WHERE id = $3`,
-			engineResp.NewFEN, newStatus, body.GameID,
+			fmt.Sprintf(`UPDATE games SET current_fen = $1, status = $2, game_state = $3, %s = $4, last_move_at = now() WHERE id = $5`, clockCol),
+			newFEN, newStatus, gameState, remainingMS, body.GameID,
 		); err != nil {
 			jsonError(w, "internal error", http.StatusInternalServerError)
 			return
@@ -364,7 +425,7 @@ func moveHandler(db *sql.DB) http.HandlerFunc {
 
 		if _, err = tx.Exec(
 			`INSERT INTO moves (game_id, ply, uci, fen_after) VALUES ($1, $2, $3, $4)`,
-			body.GameID, ply, body.UCIMove, engineResp.NewFEN,
+			body.GameID, ply, body.UCIMove, newFEN,
 		); err != nil {
 			jsonError(w, "internal error", http.StatusInternalServerError)
 			return
@@ -375,66 +436,105 @@ func moveHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		updateECO(db, body.GameID, ply)
+
+		newWhiteTimeMS, newBlackTimeMS := whiteTimeMS, blackTimeMS
+		if color == 'w' {
+			newWhiteTimeMS = remainingMS
+		} else {
+			newBlackTimeMS = remainingMS
+		}
+
+		globalGameHub.publish(body.GameID, gameEvent{Type: "move", Data: map[string]any{
+			"ply": ply, "uci": body.UCIMove, "new_fen": newFEN, "game_state": gameState, "status": newStatus,
+			"white_time_ms": newWhiteTimeMS, "black_time_ms": newBlackTimeMS,
+		}})
+		if newStatus == "finished" {
+			globalGameHub.publish(body.GameID, gameEvent{Type: "game_state", Data: map[string]string{"status": newStatus, "game_state": gameState}})
+		}
+
 		// If this is a bot game and the game is still active, fire the engine's reply.
 		opponentID := blackID
 		if claims.UserID == blackID {
 			opponentID = whiteID
 		}
 		if opponentID == 0 && newStatus == "active" {
-			go fireBotMove(db, body.GameID, engineResp.NewFEN, botDepth)
+			go fireBotMove(db, body.GameID, newFEN, botDepth)
 		}
 
-		writeJSON(w, http.StatusOK, map[string]string{
-			"status":     engineResp.Status,
-			"game_state": engineResp.GameState,
-			"new_fen":    engineResp.NewFEN,
+		writeJSON(w, http.StatusOK, map[string]any{
+			"status":        moveStatus,
+			"game_state":    gameState,
+			"new_fen":       newFEN,
+			"white_time_ms": newWhiteTimeMS,
+			"black_time_ms": newBlackTimeMS,
 		})
 	}
 }
 
+// botMoveTimeBudgetFraction is the divisor applied to the bot's remaining
+// clock: it may spend 1/botMoveTimeBudgetFraction of it thinking about a
+// single move — spend more than this and the clock,
+// not the opponent, is what eventually beats the bot.
+const botMoveTimeBudgetFraction = 20
+
+// botMoveTimeBudgetMS caps a single bot search regardless of how much clock
+// time remains, so a long time control doesn't turn into a minutes-long think.
+const botMoveTimeBudgetMS = 20000
+
+// botMoveTimeBudget allots a fraction of the bot's remaining clock to a
+// single search, capped at botMoveTimeBudgetMS. Returns 0 (no cap passed to
+// the engine) if remainingMS is zero or negative, or too small for the
+// division to leave a whole millisecond.
+func botMoveTimeBudget(remainingMS int64) int64 {
+	if remainingMS <= 0 {
+		return 0
+	}
+	budget := remainingMS / botMoveTimeBudgetFraction
+	if budget > botMoveTimeBudgetMS {
+		budget = botMoveTimeBudgetMS
+	}
+	return budget
+}
+
 // fireBotMove calls the C++ engine to pick the best move, then persists it.
 // Runs in a goroutine so it doesn't block the human player's HTTP response.
 func fireBotMove(db *sql.DB, gameID int, fen string, depth int) {
-	searchPayload, _ := json.Marshal(map[string]any{
-		"fen":   fen,
-		"depth": depth,
-	})
-	searchClient := &http.Client{Timeout: 120 * time.Second}
-	resp, err := searchClient.Post(engineURL()+"/search", "application/json", bytes.NewReader(searchPayload))
-	if err != nil {
+	start := time.Now()
+	color, colorErr := activeColor(fen)
+
+	var whiteTimeMS, blackTimeMS, incrementSeconds int64
+	if err := db.QueryRow(
+		`SELECT white_time_ms, black_time_ms, increment_seconds FROM games WHERE id = $1`, gameID,
+	).Scan(&whiteTimeMS, &blackTimeMS, &incrementSeconds); err != nil {
 		return
 	}
-	defer resp.Body.Close()
-
-	var searchResp struct {
-		BestMove string `json:"best_move"`
+	botTimeMS := whiteTimeMS
+	if colorErr == nil && color == 'b' {
+		botTimeMS = blackTimeMS
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&searchResp); err != nil || searchResp.BestMove == "" {
-		return
+	budgetMS := botMoveTimeBudget(botTimeMS)
+
+	searchTimeout := 120 * time.Second
+	if thinkTime := time.Duration(budgetMS) * time.Millisecond; budgetMS > 0 && thinkTime+5*time.Second < searchTimeout {
+		searchTimeout = thinkTime + 5*time.Second
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), searchTimeout)
+	defer cancel()
 
-	// Validate and apply the bot's chosen move through the engine.
-	movePayload, _ := json.Marshal(map[string]string{
-		"fen":      fen,
-		"uci_move": searchResp.BestMove,
-	})
-	mresp, err := engineClient.Post(engineURL()+"/move", "application/json", bytes.NewReader(movePayload))
-	if err != nil {
+	bestMove, _, err := globalEngineBroker.Search(ctx, fen, depth, budgetMS)
+	if err != nil || bestMove == "" {
 		return
 	}
-	defer mresp.Body.Close()
 
-	var engineResp struct {
-		Status    string `json:"status"`
-		GameState string `json:"game_state"`
-		NewFEN    string `json:"new_fen"`
-	}
-	if err := json.NewDecoder(mresp.Body).Decode(&engineResp); err != nil || engineResp.Status != "VALID" {
+	// Validate and apply the bot's chosen move through the engine.
+	moveStatus, gameState, newFEN, err := globalEngineBroker.Move(ctx, fen, bestMove)
+	if err != nil || moveStatus != "VALID" {
 		return
 	}
 
 	newStatus := "active"
-	switch engineResp.GameState {
+	switch gameState {
 	case "CHECKMATE", "STALEMATE", "DRAW_50_MOVE", "DRAW_INSUFFICIENT":
 		newStatus = "finished"
 	}
@@ -449,13 +549,34 @@ func fireBotMove(db *sql.DB, gameID int, fen string, depth int) {
 	if err = tx.QueryRow(`SELECT COUNT(*) + 1 FROM moves WHERE game_id = $1`, gameID).Scan(&ply); err != nil {
 		return
 	}
-	if _, err = tx.Exec(`UPDATE games SET current_fen = $1, status = $2 WHERE id = $3`, engineResp.NewFEN, newStatus, gameID); err != nil {
+
+	remainingMS := botTimeMS - time.Since(start).Milliseconds() + incrementSeconds*1000
+	clockCol := "white_time_ms"
+	if colorErr == nil && color == 'b' {
+		clockCol = "black_time_ms"
+	}
+	if _, err = tx.Exec(
+		fmt.Sprintf(`UPDATE games SET current_fen = $1, status = $2, game_state = $3, %s = $4, last_move_at = now() WHERE id = $5`, clockCol),
+		newFEN, newStatus, gameState, remainingMS, gameID,
+	); err != nil {
+		return
+	}
+	if _, err = tx.Exec(`INSERT INTO moves (game_id, ply, uci, fen_after) VALUES ($1, $2, $3, $4)`, gameID, ply, bestMove, newFEN); err != nil {
 		return
 	}
-	if _, err = tx.Exec(`INSERT INTO moves (game_id, ply, uci, fen_after) VALUES ($1, $2, $3, $4)`, gameID, ply, searchResp.BestMove, engineResp.NewFEN); err != nil {
+	if err = tx.Commit(); err != nil {
 		return
 	}
-	_ = tx.Commit()
+
+	updateECO(db, gameID, ply)
+
+	globalGameHub.publish(gameID, gameEvent{Type: "move", Data: map[string]any{
+		"ply": ply, "uci": bestMove, "new_fen": newFEN, "game_state": gameState, "status": newStatus,
+		clockCol: remainingMS,
+	}})
+	if newStatus == "finished" {
+		globalGameHub.publish(gameID, gameEvent{Type: "game_state", Data: map[string]string{"status": newStatus, "game_state": gameState}})
+	}
 }
 
 // hintHandler asks the C++ engine for the best move at depth 7.
@@ -517,32 +638,17 @@ func hintHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		payload, _ := json.Marshal(map[string]any{
-			"fen":   currentFEN,
-			"depth": 7,
-		})
-		// Use a long timeout — search can be slow on unoptimised engines.
-		searchClient := &http.Client{Timeout: 120 * time.Second}
-		resp, err := searchClient.Post(engineURL()+"/search", "application/json", bytes.NewReader(payload))
+		// Depth-7 hints are shared across callers via the broker's FEN cache
+		// and request coalescing, so hint spam on a popular position costs
+		// one engine search, not one per caller. Use a long timeout — search
+		// can be slow on unoptimised engines.
+		searchCtx, cancel := context.WithTimeout(r.Context(), 120*time.Second)
+		defer cancel()
+		bestMove, score, err := globalEngineBroker.Search(searchCtx, currentFEN, 7, 0)
 		if err != nil {
 			jsonError(w, "engine unreachable", http.StatusBadGateway)
 			return
 		}
-		defer resp.Body.Close()
-
-		var engineResp struct {
-			BestMove string `json:"best_move"`
-			Score    int    `json:"score"`
-			Error    string `json:"error"`
-		}
-		if err := json.NewDecoder(resp.Body).Decode(&engineResp); err != nil {
-			jsonError(w, "invalid engine response", http.StatusBadGateway)
-			return
-		}
-		if resp.StatusCode != http.StatusOK {
-			jsonError(w, "engine error: "+engineResp.Error, http.StatusBadGateway)
-			return
-		}
 
 		// Decrement hints only after a successful engine response.
 		col := "black_hints"
@@ -554,9 +660,13 @@ func hintHandler(db *sql.DB) http.HandlerFunc {
 			id,
 		)
 
+		globalGameHub.publish(id, gameEvent{Type: "hint_used", Data: map[string]any{
+			"user_id": claims.UserID, "hints_left": remaining - 1,
+		}})
+
 		writeJSON(w, http.StatusOK, map[string]any{
-			"best_move":  engineResp.BestMove,
-			"score":      engineResp.Score,
+			"best_move":  bestMove,
+			"score":      score,
 			"hints_left": remaining - 1,
 		})
 	}
@@ -569,6 +679,52 @@ type MoveRecord struct {
 	FENAfter string `json:"fen_after"`
 }
 
+// fetchLegalMoves asks the C++ engine for every legal move in the current
+// position, in UCI form. Shared by legalMovesHandler and moveHandler's
+// pre-check.
+func fetchLegalMoves(ctx context.Context, fen string) ([]string, error) {
+	return globalEngineBroker.LegalMoves(ctx, fen)
+}
+
+// legalMovesHandler returns the UCI list of legal moves in the game's current
+// position, for a client-side move picker.
+// GET /game/{id}/legal_moves
+func legalMovesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := claimsFromCtx(r)
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			jsonError(w, "invalid game id", http.StatusBadRequest)
+			return
+		}
+
+		var whiteID, blackID int
+		var currentFEN string
+		err = db.QueryRow(
+			`SELECT white_id, black_id, current_fen FROM games WHERE id = $1`, id,
+		).Scan(&whiteID, &blackID, &currentFEN)
+		if err == sql.ErrNoRows {
+			jsonError(w, "game not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if whiteID != claims.UserID && blackID != claims.UserID {
+			jsonError(w, "not a participant", http.StatusForbidden)
+			return
+		}
+
+		moves, err := fetchLegalMoves(r.Context(), currentFEN)
+		if err != nil {
+			jsonError(w, "engine unreachable", http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"legal_moves": moves})
+	}
+}
+
 // getGameMovesHandler returns the ordered move history for a game.
 // The JOIN ensures only participants can read the moves.
 // GET /game/{id}/moves
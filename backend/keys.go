@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// signingKey is one Ed25519 keypair identified by a kid derived from its
+// public key, so the same key always gets the same kid across restarts.
+type signingKey struct {
+	kid  string
+	priv ed25519.PrivateKey
+	pub  ed25519.PublicKey
+}
+
+func kidForPublicKey(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return base64.RawURLEncoding.EncodeToString(sum[:16])
+}
+
+// keyring holds every Ed25519 key loaded from JWT_KEYS_DIR. The most recently
+// loaded key signs new tokens; every loaded key verifies, which is what makes
+// rotation graceful — old tokens keep validating until they expire on their
+// own, even after a new signing key takes over.
+type keyring struct {
+	mu     sync.RWMutex
+	keys   map[string]*signingKey
+	newest *signingKey
+}
+
+var globalKeyring = &keyring{keys: map[string]*signingKey{}}
+
+func keysDir() string {
+	if d := os.Getenv("JWT_KEYS_DIR"); d != "" {
+		return d
+	}
+	return "./keys"
+}
+
+// load reads every *.pem file in dir, except *.pub.pem public keys, as a
+// PKCS#8 Ed25519 private key.
+// Files are sorted by name so operators control which key signs by naming
+// keys to sort last (e.g. a date-stamped filename, as cmd/keygen produces).
+func (k *keyring) load(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("read keys dir: %w", err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".pem") && !strings.HasSuffix(e.Name(), ".pub.pem") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	keys := make(map[string]*signingKey, len(names))
+	var newest *signingKey
+	for _, name := range names {
+		raw, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return fmt.Errorf("read key %s: %w", name, err)
+		}
+		block, _ := pem.Decode(raw)
+		if block == nil {
+			return fmt.Errorf("key %s: not a PEM file", name)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("key %s: %w", name, err)
+		}
+		priv, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return fmt.Errorf("key %s: not an Ed25519 key", name)
+		}
+		pub, ok := priv.Public().(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("key %s: could not derive public key", name)
+		}
+		sk := &signingKey{kid: kidForPublicKey(pub), priv: priv, pub: pub}
+		keys[sk.kid] = sk
+		newest = sk
+	}
+
+	if len(keys) == 0 {
+		return fmt.Errorf("no keys found in %s", dir)
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.newest = newest
+	k.mu.Unlock()
+	return nil
+}
+
+func (k *keyring) signingKey() (*signingKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.newest == nil {
+		return nil, fmt.Errorf("no signing key loaded")
+	}
+	return k.newest, nil
+}
+
+func (k *keyring) verifyKey(kid string) (*signingKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	sk, ok := k.keys[kid]
+	return sk, ok
+}
+
+// jwk is the JSON Web Key representation of one Ed25519 public key.
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+func (k *keyring) jwks() []jwk {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	out := make([]jwk, 0, len(k.keys))
+	for _, sk := range k.keys {
+		out = append(out, jwk{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(sk.pub),
+			Kid: sk.kid,
+			Use: "sig",
+			Alg: "EdDSA",
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Kid < out[j].Kid })
+	return out
+}
+
+// jwksHandler serves the current public keys in JWKS form so other services
+// can verify our tokens without ever holding a shared secret.
+// GET /.well-known/jwks.json
+func jwksHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"keys": globalKeyring.jwks()})
+	}
+}
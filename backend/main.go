@@ -4,26 +4,75 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
 	db := initDB(os.Getenv("DATABASE_URL"))
 	defer db.Close()
 
+	if err := globalKeyring.load(keysDir()); err != nil {
+		log.Fatalf("load signing keys: %v", err)
+	}
+
+	// SIGHUP picks up keys dropped into JWT_KEYS_DIR without a restart —
+	// the newest one starts signing, all of them keep verifying.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := globalKeyring.load(keysDir()); err != nil {
+				log.Printf("key reload failed: %v", err)
+				continue
+			}
+			log.Printf("reloaded signing keys from %s", keysDir())
+		}
+	}()
+
 	mux := http.NewServeMux()
 
 	// Public routes
 	mux.HandleFunc("POST /register", registerHandler(db))
 	mux.HandleFunc("POST /login", loginHandler(db))
+	mux.HandleFunc("POST /refresh", refreshHandler(db))
+	mux.HandleFunc("POST /logout", logoutHandler(db))
+	mux.HandleFunc("GET /.well-known/jwks.json", jwksHandler())
+	mux.HandleFunc("GET /auth/{provider}/login", oauthLoginHandler())
+	mux.HandleFunc("GET /auth/{provider}/callback", oauthCallbackHandler(db))
 
 	// Protected routes — JWT required
+	mux.Handle("POST /logout/all", jwtMiddleware(requireNoScope(logoutAllHandler(db))))
+	mux.Handle("POST /admin/unlock", jwtMiddleware(requireAdmin(adminUnlockHandler())))
+	mux.Handle("GET /metrics", jwtMiddleware(requireAdmin(metricsHandler())))
 	mux.Handle("POST /game", jwtMiddleware(createGameHandler(db)))
-	mux.Handle("POST /move", jwtMiddleware(moveHandler(db)))
-	mux.Handle("GET /users", jwtMiddleware(searchUsersHandler(db)))
-	mux.Handle("GET /game/{id}", jwtMiddleware(getGameHandler(db)))
-	mux.Handle("GET /game/{id}/moves", jwtMiddleware(getGameMovesHandler(db)))
-	mux.Handle("GET /game/{id}/hint", jwtMiddleware(hintHandler(db)))
-	mux.Handle("GET /games", jwtMiddleware(myGamesHandler(db)))
+	mux.Handle("POST /move", jwtMiddleware(requireScope("game:{id}:play", moveHandler(db))))
+	mux.Handle("GET /users", jwtMiddleware(requireNoScope(searchUsersHandler(db))))
+	mux.Handle("GET /game/{id}", jwtMiddleware(requireScope("game:{id}:play", getGameHandler(db))))
+	mux.Handle("GET /game/{id}/moves", jwtMiddleware(requireScope("game:{id}:play", getGameMovesHandler(db))))
+	mux.Handle("GET /game/{id}/legal_moves", jwtMiddleware(requireScope("game:{id}:play", legalMovesHandler(db))))
+	mux.Handle("GET /game/{id}/pgn", jwtMiddleware(requireScope("game:{id}:play", pgnHandler(db))))
+	mux.Handle("GET /game/{id}/hint", jwtMiddleware(requireScope("game:{id}:hint", hintHandler(db))))
+	mux.Handle("GET /game/{id}/stream", jwtMiddleware(requireScope("game:{id}:play", gameStreamHandler(db))))
+	mux.Handle("POST /game/{id}/messages", jwtMiddleware(requireScope("game:{id}:play", postMessageHandler(db))))
+	mux.Handle("GET /game/{id}/messages", jwtMiddleware(requireScope("game:{id}:play", getMessagesHandler(db))))
+	mux.Handle("GET /games", jwtMiddleware(requireNoScope(myGamesHandler(db))))
+	mux.Handle("POST /queue", jwtMiddleware(requireNoScope(enterQueueHandler(db))))
+	mux.Handle("DELETE /queue", jwtMiddleware(requireNoScope(leaveQueueHandler(db))))
+	mux.Handle("POST /challenge", jwtMiddleware(requireNoScope(challengeHandler(db))))
+	mux.Handle("POST /challenge/{id}/accept", jwtMiddleware(requireNoScope(respondToChallengeHandler(db, true))))
+	mux.Handle("POST /challenge/{id}/decline", jwtMiddleware(requireNoScope(respondToChallengeHandler(db, false))))
+	mux.Handle("POST /games/import", jwtMiddleware(requireAdmin(importGameHandler(db))))
+
+	startMatchmaker(db)
+	startClockSweeper(db)
+
+	globalEngineBroker.startHealthchecks()
+	if os.Getenv("ENGINE_URLS") == "" {
+		if urls, err := loadEngineRegistry(db); err == nil && len(urls) > 0 {
+			globalEngineBroker.setBackends(urls)
+		}
+	}
 
 	addr := ":8080"
 	log.Printf("Referee listening on %s", addr)
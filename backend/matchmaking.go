@@ -0,0 +1,246 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// matchmakingPollInterval is how often startMatchmaker looks for a pair of
+// compatible waiting players.
+const matchmakingPollInterval = 2 * time.Second
+
+// enterQueueHandler adds the caller to the matchmaking pool. startMatchmaker
+// pairs waiting users by time control (oldest-waiting first) and starts a
+// game for them; re-entering the queue just refreshes the caller's entry.
+// POST /queue  {"time_control":"5+0"}
+func enterQueueHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := claimsFromCtx(r)
+
+		var body struct {
+			TimeControl string `json:"time_control"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body.TimeControl == "" {
+			body.TimeControl = "unrated"
+		}
+
+		_, err := db.Exec(
+			`INSERT INTO queue_entries (user_id, time_control) VALUES ($1, $2)
+			 ON CONFLICT (user_id) DO UPDATE SET time_control = EXCLUDED.time_control, created_at = now()`,
+			claims.UserID, body.TimeControl,
+		)
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{"status": "queued"})
+	}
+}
+
+// leaveQueueHandler removes the caller from the matchmaking pool.
+// DELETE /queue
+func leaveQueueHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := claimsFromCtx(r)
+		if _, err := db.Exec(`DELETE FROM queue_entries WHERE user_id = $1`, claims.UserID); err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "left"})
+	}
+}
+
+// startMatchmaker runs matchOnce on matchmakingPollInterval for the lifetime
+// of the process.
+func startMatchmaker(db *sql.DB) {
+	go func() {
+		for {
+			time.Sleep(matchmakingPollInterval)
+			if err := matchOnce(db); err != nil {
+				log.Printf("matchmaker: %v", err)
+			}
+		}
+	}()
+}
+
+// parseTimeControl parses the "minutes+incrementSeconds" format used by
+// enterQueueHandler's time_control field (e.g. "5+0" = 5 minutes, no
+// increment). Falls back to the server default for anything it can't parse,
+// including the "unrated" placeholder.
+func parseTimeControl(tc string) (initialSeconds, incrementSeconds int) {
+	minutes, increment, ok := strings.Cut(tc, "+")
+	m, err := strconv.Atoi(minutes)
+	if !ok || err != nil || m <= 0 {
+		return defaultInitialSeconds, defaultIncrementSeconds
+	}
+	i, err := strconv.Atoi(increment)
+	if err != nil || i < 0 {
+		i = defaultIncrementSeconds
+	}
+	return m * 60, i
+}
+
+// matchOnce pairs up waiting players who share a time control, oldest
+// waiting first, and starts a game for each pair.
+func matchOnce(db *sql.DB) error {
+	rows, err := db.Query(`SELECT user_id, time_control FROM queue_entries ORDER BY time_control, created_at`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type waitingPlayer struct {
+		userID      int
+		timeControl string
+	}
+	byTimeControl := map[string][]waitingPlayer{}
+	for rows.Next() {
+		var p waitingPlayer
+		if err := rows.Scan(&p.userID, &p.timeControl); err != nil {
+			continue
+		}
+		byTimeControl[p.timeControl] = append(byTimeControl[p.timeControl], p)
+	}
+
+	for _, pool := range byTimeControl {
+		for len(pool) >= 2 {
+			a, b := pool[0], pool[1]
+			pool = pool[2:]
+
+			var whiteUsername, blackUsername string
+			if err := db.QueryRow(`SELECT username FROM users WHERE id = $1`, a.userID).Scan(&whiteUsername); err != nil {
+				continue
+			}
+			if err := db.QueryRow(`SELECT username FROM users WHERE id = $1`, b.userID).Scan(&blackUsername); err != nil {
+				continue
+			}
+
+			initialSeconds, incrementSeconds := parseTimeControl(a.timeControl)
+			if _, _, _, err := startGame(db, a.userID, whiteUsername, b.userID, blackUsername, initialSeconds, incrementSeconds); err != nil {
+				continue
+			}
+			_, _ = db.Exec(`DELETE FROM queue_entries WHERE user_id = $1 OR user_id = $2`, a.userID, b.userID)
+		}
+	}
+	return nil
+}
+
+// challengeHandler sends a direct challenge to another user.
+// POST /challenge  {"to_username":"bob"}
+func challengeHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := claimsFromCtx(r)
+
+		var body struct {
+			ToUsername string `json:"to_username"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ToUsername == "" {
+			jsonError(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+
+		var toID int
+		err := db.QueryRow(`SELECT id FROM users WHERE username = $1`, body.ToUsername).Scan(&toID)
+		if err == sql.ErrNoRows {
+			jsonError(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if toID == claims.UserID {
+			jsonError(w, "cannot challenge yourself", http.StatusBadRequest)
+			return
+		}
+
+		var challengeID int
+		err = db.QueryRow(
+			`INSERT INTO challenges (from_id, to_id) VALUES ($1, $2) RETURNING id`,
+			claims.UserID, toID,
+		).Scan(&challengeID)
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, map[string]int{"challenge_id": challengeID})
+	}
+}
+
+// respondToChallengeHandler accepts or declines a pending challenge addressed
+// to the caller. Accepting starts the game the same way the matchmaker does.
+func respondToChallengeHandler(db *sql.DB, accept bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := claimsFromCtx(r)
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			jsonError(w, "invalid challenge id", http.StatusBadRequest)
+			return
+		}
+
+		var fromID, toID int
+		var status string
+		err = db.QueryRow(`SELECT from_id, to_id, status FROM challenges WHERE id = $1`, id).Scan(&fromID, &toID, &status)
+		if err == sql.ErrNoRows {
+			jsonError(w, "challenge not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if toID != claims.UserID {
+			jsonError(w, "not your challenge", http.StatusForbidden)
+			return
+		}
+		if status != "pending" {
+			jsonError(w, "challenge already resolved", http.StatusConflict)
+			return
+		}
+
+		if !accept {
+			if _, err := db.Exec(`UPDATE challenges SET status = 'declined' WHERE id = $1`, id); err != nil {
+				jsonError(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]string{"status": "declined"})
+			return
+		}
+
+		var fromUsername, toUsername string
+		if err := db.QueryRow(`SELECT username FROM users WHERE id = $1`, fromID).Scan(&fromUsername); err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if err := db.QueryRow(`SELECT username FROM users WHERE id = $1`, toID).Scan(&toUsername); err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		gameID, whiteToken, blackToken, err := startGame(db, fromID, fromUsername, toID, toUsername, defaultInitialSeconds, defaultIncrementSeconds)
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := db.Exec(`UPDATE challenges SET status = 'accepted' WHERE id = $1`, id); err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{
+			"status":      "accepted",
+			"game_id":     gameID,
+			"white_token": whiteToken,
+			"black_token": blackToken,
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Message is a single chat message exchanged between a game's participants.
+type Message struct {
+	ID        int       `json:"id"`
+	GameID    int       `json:"game_id"`
+	SenderID  int       `json:"sender_id"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// postMessageHandler appends a chat message to a game and publishes it over
+// the real-time stream so the opponent sees it immediately.
+// POST /game/{id}/messages  {"body":"gg"}
+func postMessageHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := claimsFromCtx(r)
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			jsonError(w, "invalid game id", http.StatusBadRequest)
+			return
+		}
+
+		var body struct {
+			Body string `json:"body"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Body == "" {
+			jsonError(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+
+		var whiteID, blackID int
+		err = db.QueryRow(`SELECT white_id, black_id FROM games WHERE id = $1`, id).Scan(&whiteID, &blackID)
+		if err == sql.ErrNoRows {
+			jsonError(w, "game not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if whiteID != claims.UserID && blackID != claims.UserID {
+			jsonError(w, "not a participant", http.StatusForbidden)
+			return
+		}
+
+		m := Message{GameID: id, SenderID: claims.UserID, Body: body.Body}
+		err = db.QueryRow(
+			`INSERT INTO messages (game_id, sender_id, body) VALUES ($1, $2, $3) RETURNING id, created_at`,
+			id, claims.UserID, body.Body,
+		).Scan(&m.ID, &m.CreatedAt)
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		globalGameHub.publish(id, gameEvent{Type: "chat", Data: m})
+
+		writeJSON(w, http.StatusCreated, m)
+	}
+}
+
+// getMessagesHandler returns a game's chat history. The JOIN enforces
+// participant-only access, the same pattern as getGameMovesHandler.
+// GET /game/{id}/messages
+func getMessagesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := claimsFromCtx(r)
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			jsonError(w, "invalid game id", http.StatusBadRequest)
+			return
+		}
+
+		rows, err := db.Query(`
+			SELECT m.id, m.game_id, m.sender_id, m.body, m.created_at
+			FROM messages m
+			JOIN games g ON g.id = m.game_id
+			WHERE m.game_id = $1
+			  AND (g.white_id = $2 OR g.black_id = $2)
+			ORDER BY m.id`,
+			id, claims.UserID,
+		)
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		messages := []Message{}
+		for rows.Next() {
+			var m Message
+			if err := rows.Scan(&m.ID, &m.GameID, &m.SenderID, &m.Body, &m.CreatedAt); err == nil {
+				messages = append(messages, m)
+			}
+		}
+		writeJSON(w, http.StatusOK, messages)
+	}
+}
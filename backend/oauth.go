@@ -0,0 +1,346 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// oauthProvider bundles an OAuth2 config with the userinfo endpoint needed to
+// resolve a stable subject+email after the code exchange.
+type oauthProvider struct {
+	config      *oauth2.Config
+	userInfoURL string
+	usePKCE     bool
+}
+
+// oauthProviders is populated at startup from whichever OAUTH_<NAME>_* env
+// vars are actually set — an unconfigured provider simply isn't registered,
+// so /auth/{provider}/login 404s instead of redirecting to a broken client.
+var oauthProviders = map[string]*oauthProvider{}
+
+func init() {
+	registerOAuthProvider("google", "https://accounts.google.com/o/oauth2/v2/auth", "https://oauth2.googleapis.com/token", "https://openidconnect.googleapis.com/v1/userinfo", []string{"openid", "email"}, true)
+	registerOAuthProvider("github", "https://github.com/login/oauth/authorize", "https://github.com/login/oauth/access_token", "https://api.github.com/user", []string{"read:user", "user:email"}, false)
+	registerOAuthProvider("lichess", "https://lichess.org/oauth", "https://lichess.org/api/token", "https://lichess.org/api/account", []string{"email:read"}, true)
+}
+
+func registerOAuthProvider(name, authURL, tokenURL, userInfoURL string, scopes []string, pkce bool) {
+	envName := strings.ToUpper(name)
+	clientID := os.Getenv(fmt.Sprintf("OAUTH_%s_CLIENT_ID", envName))
+	if clientID == "" {
+		return
+	}
+	clientSecret := os.Getenv(fmt.Sprintf("OAUTH_%s_CLIENT_SECRET", envName))
+
+	oauthProviders[name] = &oauthProvider{
+		config: &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     oauth2.Endpoint{AuthURL: authURL, TokenURL: tokenURL},
+			RedirectURL:  os.Getenv("OAUTH_REDIRECT_BASE_URL") + "/auth/" + name + "/callback",
+			Scopes:       scopes,
+		},
+		userInfoURL: userInfoURL,
+		usePKCE:     pkce,
+	}
+}
+
+// oauthStateSecret signs the state cookie so a forged or replayed state value
+// gets rejected at the callback. Falls back to a per-process random secret —
+// fine, since the cookie only needs to survive one login round trip.
+var oauthStateSecret = mustOAuthStateSecret()
+
+func mustOAuthStateSecret() []byte {
+	if s := os.Getenv("OAUTH_STATE_SECRET"); s != "" {
+		return []byte(s)
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("oauth: failed to generate state secret: " + err.Error())
+	}
+	return b
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// oauthState is stashed in a signed, HttpOnly cookie between the login
+// redirect and the callback — the nonce guards CSRF, codeVerifier carries
+// the PKCE secret for providers that support it.
+type oauthState struct {
+	Nonce        string `json:"n"`
+	CodeVerifier string `json:"cv,omitempty"`
+}
+
+func packOAuthState(s oauthState) (string, error) {
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, oauthStateSecret)
+	mac.Write(raw)
+	return base64.RawURLEncoding.EncodeToString(raw) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func unpackOAuthState(packed string) (oauthState, error) {
+	var s oauthState
+	parts := strings.SplitN(packed, ".", 2)
+	if len(parts) != 2 {
+		return s, fmt.Errorf("malformed oauth state")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return s, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return s, err
+	}
+	mac := hmac.New(sha256.New, oauthStateSecret)
+	mac.Write(raw)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return s, fmt.Errorf("oauth state signature mismatch")
+	}
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// oauthLoginHandler redirects to the provider's consent screen, stashing a
+// signed nonce (and, for providers that support it, a PKCE verifier) in a
+// short-lived cookie scoped to the matching callback path.
+// GET /auth/{provider}/login
+func oauthLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("provider")
+		p, ok := oauthProviders[name]
+		if !ok {
+			jsonError(w, "unknown or unconfigured provider", http.StatusNotFound)
+			return
+		}
+
+		nonce, err := randomToken(16)
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		state := oauthState{Nonce: nonce}
+		var opts []oauth2.AuthCodeOption
+		if p.usePKCE {
+			verifier := oauth2.GenerateVerifier()
+			state.CodeVerifier = verifier
+			opts = append(opts, oauth2.S256ChallengeOption(verifier))
+		}
+
+		packed, err := packOAuthState(state)
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     "oauth_state_" + name,
+			Value:    packed,
+			Path:     "/auth/" + name + "/callback",
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+			MaxAge:   10 * 60,
+		})
+
+		http.Redirect(w, r, p.config.AuthCodeURL(nonce, opts...), http.StatusFound)
+	}
+}
+
+// oauthCallbackHandler exchanges the authorization code, fetches the
+// provider's userinfo, links it to a user_identities row (creating a new
+// user on first login), and mints the same JWT the password flow does so
+// downstream handlers are unchanged.
+// GET /auth/{provider}/callback?code=...&state=...
+func oauthCallbackHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("provider")
+		p, ok := oauthProviders[name]
+		if !ok {
+			jsonError(w, "unknown or unconfigured provider", http.StatusNotFound)
+			return
+		}
+
+		cookie, err := r.Cookie("oauth_state_" + name)
+		if err != nil {
+			jsonError(w, "missing oauth state", http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "oauth_state_" + name, Value: "", Path: "/auth/" + name + "/callback", MaxAge: -1})
+
+		state, err := unpackOAuthState(cookie.Value)
+		if err != nil || state.Nonce != r.URL.Query().Get("state") {
+			jsonError(w, "invalid oauth state", http.StatusBadRequest)
+			return
+		}
+
+		var opts []oauth2.AuthCodeOption
+		if p.usePKCE {
+			opts = append(opts, oauth2.VerifierOption(state.CodeVerifier))
+		}
+
+		token, err := p.config.Exchange(r.Context(), r.URL.Query().Get("code"), opts...)
+		if err != nil {
+			jsonError(w, "oauth exchange failed", http.StatusBadGateway)
+			return
+		}
+
+		subject, email, err := fetchOAuthUserInfo(r, p, name, token)
+		if err != nil {
+			jsonError(w, "failed to fetch user info", http.StatusBadGateway)
+			return
+		}
+
+		userID, username, err := linkOAuthIdentity(db, name, subject, email)
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		accessToken, refreshToken, err := issueSession(db, userID, username)
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{"token": accessToken, "refresh_token": refreshToken})
+	}
+}
+
+// fetchOAuthUserInfo calls the provider's userinfo endpoint and normalises
+// its response into a stable subject + email. Each provider shapes this
+// response differently, hence the per-provider branch.
+func fetchOAuthUserInfo(r *http.Request, p *oauthProvider, provider string, token *oauth2.Token) (subject, email string, err error) {
+	client := p.config.Client(r.Context(), token)
+	resp, err := client.Get(p.userInfoURL)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("userinfo: unexpected status %d", resp.StatusCode)
+	}
+
+	switch provider {
+	case "github":
+		var body struct {
+			ID    int    `json:"id"`
+			Email string `json:"email"`
+			Login string `json:"login"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return "", "", err
+		}
+		email = body.Email
+		if email == "" {
+			email = body.Login + "@users.noreply.github.com"
+		}
+		return strconv.Itoa(body.ID), email, nil
+	case "lichess":
+		var body struct {
+			ID    string `json:"id"`
+			Email string `json:"email"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return "", "", err
+		}
+		return body.ID, body.Email, nil
+	default: // google and any other OIDC-compliant provider
+		var body struct {
+			Sub   string `json:"sub"`
+			Email string `json:"email"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			return "", "", err
+		}
+		return body.Sub, body.Email, nil
+	}
+}
+
+// linkOAuthIdentity finds the user already linked to (provider, subject), or
+// creates one on first login with the unloginnable 'NO_LOGIN' password_hash
+// sentinel — the same one as the seeded Engine bot in db.go.
+func linkOAuthIdentity(db *sql.DB, provider, subject, email string) (userID int, username string, err error) {
+	err = db.QueryRow(
+		`SELECT u.id, u.username FROM user_identities ui JOIN users u ON u.id = ui.user_id
+		 WHERE ui.provider = $1 AND ui.subject = $2`,
+		provider, subject,
+	).Scan(&userID, &username)
+	if err == nil {
+		return userID, username, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, "", err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	// provider_subject collides if a password account already claimed that
+	// username (e.g. someone registered "github_12345" directly). ON
+	// CONFLICT DO NOTHING lets us detect that without aborting the
+	// transaction (a plain unique-violation here would poison tx for every
+	// statement after it), so we retry with a random suffix, up to five times,
+	// rather than 500ing the login on the first collision.
+	base := fmt.Sprintf("%s_%s", provider, subject)
+	username = base
+	for attempt := 0; ; attempt++ {
+		err = tx.QueryRow(
+			`INSERT INTO users (username, password_hash) VALUES ($1, 'NO_LOGIN')
+			 ON CONFLICT (username) DO NOTHING RETURNING id`,
+			username,
+		).Scan(&userID)
+		if err == nil {
+			break
+		}
+		if err != sql.ErrNoRows {
+			return 0, "", err
+		}
+		if attempt >= 5 {
+			return 0, "", fmt.Errorf("oauth: could not allocate a username for %s/%s", provider, subject)
+		}
+		suffix, rerr := randomToken(4)
+		if rerr != nil {
+			return 0, "", rerr
+		}
+		username = base + "_" + suffix
+	}
+
+	if _, err = tx.Exec(
+		`INSERT INTO user_identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)`,
+		userID, provider, subject, email,
+	); err != nil {
+		return 0, "", err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, "", err
+	}
+	return userID, username, nil
+}
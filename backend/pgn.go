@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// startingFEN is the board position new games are created with — kept in
+// sync with the current_fen column's default in db.go.
+const startingFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+// sanForMove asks the C++ engine to render a UCI move as SAN for the given
+// position. Used both to build export movetext (one call per played move)
+// and, during import, to identify which legal UCI move a SAN token in the
+// uploaded PGN refers to.
+func sanForMove(ctx context.Context, fen, uci string) (string, error) {
+	return globalEngineBroker.SAN(ctx, fen, uci)
+}
+
+// errNoSANMatch means every legal move was checked (successfully) and none
+// rendered as the requested SAN — the PGN itself is bad, not the engine.
+var errNoSANMatch = fmt.Errorf("no legal move matches SAN")
+
+// resolveSANMove finds which of legalMoves renders as san in the position
+// fen. The engine only exposes a one-move-at-a-time /san endpoint, so a
+// naive import would serialize one round trip per legal move per ply —
+// hundreds to thousands of sequential calls for a full game. Instead we
+// fan the candidates out concurrently and cancel the rest as soon as one
+// matches.
+func resolveSANMove(ctx context.Context, fen, san string, legalMoves []string) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type outcome struct {
+		uci string
+		err error
+	}
+	results := make(chan outcome, len(legalMoves))
+	var wg sync.WaitGroup
+	for _, candidate := range legalMoves {
+		wg.Add(1)
+		go func(candidate string) {
+			defer wg.Done()
+			candidateSAN, err := sanForMove(ctx, fen, candidate)
+			if err != nil {
+				results <- outcome{err: err}
+				return
+			}
+			if candidateSAN == san {
+				results <- outcome{uci: candidate}
+				return
+			}
+			results <- outcome{}
+		}(candidate)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var firstErr error
+	for o := range results {
+		if o.uci != "" {
+			return o.uci, nil
+		}
+		if o.err != nil && firstErr == nil && ctx.Err() == nil {
+			firstErr = o.err
+		}
+	}
+	if firstErr != nil {
+		return "", firstErr
+	}
+	return "", fmt.Errorf("%w %q", errNoSANMatch, san)
+}
+
+// pgnHandler materializes a game's move history as standard PGN: a seven-tag
+// roster filled from games+users, movetext converted to SAN ply by ply via
+// the engine, and a Result tag derived from status/game_state.
+// GET /game/{id}/pgn
+func pgnHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := claimsFromCtx(r)
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			jsonError(w, "invalid game id", http.StatusBadRequest)
+			return
+		}
+
+		var whiteID, blackID int
+		var whiteUsername, blackUsername, status, gameState, ecoCode, currentFEN string
+		var createdAt time.Time
+		err = db.QueryRow(`
+			SELECT g.white_id, g.black_id, wu.username, bu.username,
+			       g.status, g.game_state, g.eco_code, g.current_fen, g.created_at
+			FROM games g
+			JOIN users wu ON wu.id = g.white_id
+			JOIN users bu ON bu.id = g.black_id
+			WHERE g.id = $1`, id,
+		).Scan(&whiteID, &blackID, &whiteUsername, &blackUsername,
+			&status, &gameState, &ecoCode, &currentFEN, &createdAt)
+		if err == sql.ErrNoRows {
+			jsonError(w, "game not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if whiteID != claims.UserID && blackID != claims.UserID {
+			jsonError(w, "not a participant", http.StatusForbidden)
+			return
+		}
+
+		rows, err := db.Query(`SELECT uci, fen_after FROM moves WHERE game_id = $1 ORDER BY ply`, id)
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		var ucis, fensAfter []string
+		for rows.Next() {
+			var uci, fenAfter string
+			if err := rows.Scan(&uci, &fenAfter); err != nil {
+				jsonError(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			ucis = append(ucis, uci)
+			fensAfter = append(fensAfter, fenAfter)
+		}
+
+		pgn, err := buildPGN(r.Context(), pgnHeaders{
+			White:     whiteUsername,
+			Black:     blackUsername,
+			CreatedAt: createdAt,
+			ECO:       ecoCode,
+			Result:    pgnResult(status, gameState, currentFEN),
+		}, ucis, fensAfter)
+		if err != nil {
+			jsonError(w, "engine unreachable", http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-chess-pgn")
+		w.Write([]byte(pgn))
+	}
+}
+
+// pgnResult derives the PGN Result tag from the game's stored status and
+// final game_state. TIMEOUT doesn't itself say who flagged, so it falls back
+// to whoever is still on move in the final FEN — the clock check in
+// moveHandler/sweepClocks only ever finishes a game on the mover's own flag.
+func pgnResult(status, gameState, currentFEN string) string {
+	if status != "finished" {
+		return "*"
+	}
+	switch gameState {
+	case "CHECKMATE":
+		if color, err := activeColor(currentFEN); err == nil {
+			if color == 'b' {
+				return "1-0"
+			}
+			return "0-1"
+		}
+	case "STALEMATE", "DRAW_50_MOVE", "DRAW_INSUFFICIENT":
+		return "1/2-1/2"
+	case "TIMEOUT":
+		if color, err := activeColor(currentFEN); err == nil {
+			if color == 'w' {
+				return "0-1"
+			}
+			return "1-0"
+		}
+	}
+	return "*"
+}
+
+// pgnHeaders holds the seven-tag roster values plus the ECO tag.
+type pgnHeaders struct {
+	White, Black string
+	CreatedAt    time.Time
+	ECO, Result  string
+}
+
+// buildPGN renders the seven-tag roster followed by SAN movetext, replaying
+// ucis forward from the starting position. fensAfter[i] is the board after
+// ucis[i] — the SAN for ucis[i] is always rendered against the FEN before it
+// (the starting position, or fensAfter[i-1]).
+func buildPGN(ctx context.Context, h pgnHeaders, ucis, fensAfter []string) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[Event \"DistributedChess\"]\n")
+	fmt.Fprintf(&b, "[Site \"Referee\"]\n")
+	fmt.Fprintf(&b, "[Date \"%s\"]\n", h.CreatedAt.UTC().Format("2006.01.02"))
+	fmt.Fprintf(&b, "[Round \"-\"]\n")
+	fmt.Fprintf(&b, "[White \"%s\"]\n", h.White)
+	fmt.Fprintf(&b, "[Black \"%s\"]\n", h.Black)
+	fmt.Fprintf(&b, "[Result \"%s\"]\n", h.Result)
+	if h.ECO != "" {
+		fmt.Fprintf(&b, "[ECO \"%s\"]\n", h.ECO)
+	}
+	b.WriteString("\n")
+
+	fen := startingFEN
+	for i, uci := range ucis {
+		san, err := sanForMove(ctx, fen, uci)
+		if err != nil {
+			return "", err
+		}
+		if i%2 == 0 {
+			fmt.Fprintf(&b, "%d. ", i/2+1)
+		}
+		b.WriteString(san)
+		b.WriteString(" ")
+		fen = fensAfter[i]
+	}
+	b.WriteString(h.Result)
+	b.WriteString("\n")
+	return b.String(), nil
+}
+
+// pgnTagRE pulls "Tag "value"" pairs out of a PGN header block.
+var pgnTagRE = regexp.MustCompile(`\[(\w+)\s+"([^"]*)"\]`)
+
+// pgnMoveNumberRE strips "12." / "12..." move numbers from movetext.
+var pgnMoveNumberRE = regexp.MustCompile(`\d+\.(\.\.)?`)
+
+// parsePGN splits a PGN document into its tag pairs and the SAN tokens of
+// its mainline, dropping move numbers, the trailing result marker, and
+// brace comments.
+func parsePGN(pgn string) (tags map[string]string, sanMoves []string) {
+	tags = map[string]string{}
+	var movetext strings.Builder
+	for _, line := range strings.Split(pgn, "\n") {
+		line = strings.TrimSpace(line)
+		if m := pgnTagRE.FindStringSubmatch(line); m != nil {
+			tags[m[1]] = m[2]
+			continue
+		}
+		movetext.WriteString(line)
+		movetext.WriteString(" ")
+	}
+
+	text := movetext.String()
+	for strings.Contains(text, "{") {
+		start := strings.Index(text, "{")
+		end := strings.Index(text, "}")
+		if end < start {
+			break
+		}
+		text = text[:start] + text[end+1:]
+	}
+	text = pgnMoveNumberRE.ReplaceAllString(text, "")
+
+	for _, tok := range strings.Fields(text) {
+		switch tok {
+		case "1-0", "0-1", "1/2-1/2", "*":
+			continue
+		}
+		sanMoves = append(sanMoves, tok)
+	}
+	return tags, sanMoves
+}
+
+// resultToStatus maps a PGN Result tag to the finished game's game_state,
+// mirroring the values moveHandler persists for a live game's natural end.
+// An imported game with no players left to move is always "finished"; an
+// unset/unknown result ("*" or empty) is stored as "UNKNOWN", which
+// pgnResult exports as "*". Import never re-derives checkmate vs.
+// resignation from the engine.
+func resultToStatus(result string) string {
+	switch result {
+	case "1/2-1/2":
+		return "DRAW_50_MOVE"
+	case "1-0", "0-1":
+		return "CHECKMATE"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// importGameHandler validates every ply of an uploaded PGN through the
+// engine's legal-move list and creates a finished game row with full move
+// history — the reverse of pgnHandler. Restricted to admins for the same
+// reason direct game creation is: it bypasses matchmaking and challenges
+// entirely.
+// POST /games/import  {"pgn":"[Event \"...\"]\n...\n1. e4 e5 ..."}
+func importGameHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			PGN string `json:"pgn"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.PGN == "" {
+			jsonError(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+
+		tags, sanMoves := parsePGN(body.PGN)
+		whiteUsername, blackUsername := tags["White"], tags["Black"]
+		if whiteUsername == "" || blackUsername == "" {
+			jsonError(w, "PGN missing White/Black tags", http.StatusBadRequest)
+			return
+		}
+
+		var whiteID int
+		err := db.QueryRow(`SELECT id FROM users WHERE username = $1`, whiteUsername).Scan(&whiteID)
+		if err == sql.ErrNoRows {
+			jsonError(w, "white player not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		var blackID int
+		err = db.QueryRow(`SELECT id FROM users WHERE username = $1`, blackUsername).Scan(&blackID)
+		if err == sql.ErrNoRows {
+			jsonError(w, "black player not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		fen := startingFEN
+		ucis := make([]string, 0, len(sanMoves))
+		fensAfter := make([]string, 0, len(sanMoves))
+		for _, san := range sanMoves {
+			legalMoves, err := fetchLegalMoves(r.Context(), fen)
+			if err != nil {
+				jsonError(w, "engine unreachable", http.StatusBadGateway)
+				return
+			}
+
+			uci, err := resolveSANMove(r.Context(), fen, san, legalMoves)
+			if errors.Is(err, errNoSANMatch) {
+				jsonError(w, fmt.Sprintf("invalid move %q at ply %d", san, len(ucis)+1), http.StatusUnprocessableEntity)
+				return
+			}
+			if err != nil {
+				jsonError(w, "engine unreachable", http.StatusBadGateway)
+				return
+			}
+
+			moveStatus, _, newFEN, err := globalEngineBroker.Move(r.Context(), fen, uci)
+			if err != nil {
+				jsonError(w, "engine unreachable", http.StatusBadGateway)
+				return
+			}
+			if moveStatus != "VALID" {
+				jsonError(w, fmt.Sprintf("invalid move %q at ply %d", san, len(ucis)+1), http.StatusUnprocessableEntity)
+				return
+			}
+
+			ucis = append(ucis, uci)
+			fensAfter = append(fensAfter, newFEN)
+			fen = newFEN
+		}
+
+		ecoCode, _ := classifyECO(ucis)
+		gameState := resultToStatus(tags["Result"])
+
+		tx, err := db.Begin()
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback() //nolint:errcheck
+
+		var gameID int
+		if err = tx.QueryRow(
+			`INSERT INTO games (white_id, black_id, current_fen, status, game_state, eco_code)
+			 VALUES ($1, $2, $3, 'finished', $4, $5) RETURNING id`,
+			whiteID, blackID, fen, gameState, ecoCode,
+		).Scan(&gameID); err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		for i, uci := range ucis {
+			if _, err = tx.Exec(
+				`INSERT INTO moves (game_id, ply, uci, fen_after) VALUES ($1, $2, $3, $4)`,
+				gameID, i+1, uci, fensAfter[i],
+			); err != nil {
+				jsonError(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if err = tx.Commit(); err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, map[string]any{"game_id": gameID, "ply_count": len(ucis)})
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// refreshTokenTTL is long-lived by design — it's the access token in
+// accessTokenTTL that limits how long a stolen token stays useful.
+const refreshTokenTTL = 30 * 24 * time.Hour
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRefreshToken returns a fresh opaque, cryptographically-random refresh
+// token and the hash under which it's stored.
+func newRefreshToken() (token, hash string, err error) {
+	raw := make([]byte, 32)
+	if _, err = rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashRefreshToken(token), nil
+}
+
+// issueSession mints a fresh access token and records a new refresh token
+// row for userID, returning both to send to the client.
+func issueSession(db *sql.DB, userID int, username string) (accessToken, refreshToken string, err error) {
+	var isAdmin bool
+	if err = db.QueryRow(`SELECT is_admin FROM users WHERE id = $1`, userID).Scan(&isAdmin); err != nil {
+		return "", "", err
+	}
+
+	accessToken, err = signScopedToken(userID, username, nil, isAdmin)
+	if err != nil {
+		return "", "", err
+	}
+
+	token, hash, err := newRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	if _, err = db.Exec(
+		`INSERT INTO refresh_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`,
+		userID, hash, time.Now().Add(refreshTokenTTL),
+	); err != nil {
+		return "", "", err
+	}
+
+	return accessToken, token, nil
+}
+
+// refreshHandler rotates a refresh token: the presented token is atomically
+// marked used and replaced by a new access+refresh pair. Presenting a token
+// that was already consumed can only mean it was copied and used by someone
+// else first, so the whole chain for that user is revoked on reuse.
+// POST /refresh  {"refresh_token":"..."}
+func refreshHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RefreshToken == "" {
+			jsonError(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback() //nolint:errcheck
+
+		var id, userID int
+		var username string
+		var expiresAt time.Time
+		var revokedAt sql.NullTime
+		err = tx.QueryRow(
+			`SELECT rt.id, rt.user_id, u.username, rt.expires_at, rt.revoked_at
+			 FROM refresh_tokens rt JOIN users u ON u.id = rt.user_id
+			 WHERE rt.token_hash = $1 FOR UPDATE OF rt`,
+			hashRefreshToken(body.RefreshToken),
+		).Scan(&id, &userID, &username, &expiresAt, &revokedAt)
+		if err == sql.ErrNoRows {
+			jsonError(w, "invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		if revokedAt.Valid {
+			// Reuse of an already-consumed token — treat it as stolen and
+			// kill every other token still alive in this user's chain.
+			if _, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`, userID); err != nil {
+				jsonError(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			if err := tx.Commit(); err != nil {
+				jsonError(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			jsonError(w, "invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		if time.Now().After(expiresAt) {
+			jsonError(w, "invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+
+		newToken, newHash, err := newRefreshToken()
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		var newID int
+		if err := tx.QueryRow(
+			`INSERT INTO refresh_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3) RETURNING id`,
+			userID, newHash, time.Now().Add(refreshTokenTTL),
+		).Scan(&newID); err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := tx.Exec(
+			`UPDATE refresh_tokens SET revoked_at = now(), replaced_by = $1 WHERE id = $2`,
+			newID, id,
+		); err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		var isAdmin bool
+		if err := tx.QueryRow(`SELECT is_admin FROM users WHERE id = $1`, userID).Scan(&isAdmin); err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		accessToken, err := signScopedToken(userID, username, nil, isAdmin)
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{"token": accessToken, "refresh_token": newToken})
+	}
+}
+
+// logoutHandler revokes the presented refresh token.
+// POST /logout  {"refresh_token":"..."}
+func logoutHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RefreshToken == "" {
+			jsonError(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+
+		if _, err := db.Exec(
+			`UPDATE refresh_tokens SET revoked_at = now() WHERE token_hash = $1 AND revoked_at IS NULL`,
+			hashRefreshToken(body.RefreshToken),
+		); err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// logoutAllHandler revokes every refresh token belonging to the
+// authenticated user, ending every session at once.
+// POST /logout/all
+func logoutAllHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := claimsFromCtx(r)
+
+		if _, err := db.Exec(
+			`UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`,
+			claims.UserID,
+		); err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
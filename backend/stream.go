@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// gameEvent is one message pushed to a game's stream subscribers.
+type gameEvent struct {
+	Type string `json:"type"` // "move", "game_state", "hint_used", "chat"
+	Data any    `json:"data"`
+}
+
+// gameHub fans out gameEvents to every subscriber of a game, keyed by game
+// ID, so moveHandler/fireBotMove/hintHandler can push updates instead of
+// clients polling GET /game/{id} and GET /game/{id}/moves.
+type gameHub struct {
+	mu   sync.Mutex
+	subs map[int]map[chan gameEvent]struct{}
+}
+
+var globalGameHub = &gameHub{subs: map[int]map[chan gameEvent]struct{}{}}
+
+func (h *gameHub) subscribe(gameID int) chan gameEvent {
+	ch := make(chan gameEvent, 8)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[gameID] == nil {
+		h.subs[gameID] = map[chan gameEvent]struct{}{}
+	}
+	h.subs[gameID][ch] = struct{}{}
+	return ch
+}
+
+func (h *gameHub) unsubscribe(gameID int, ch chan gameEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs[gameID], ch)
+	if len(h.subs[gameID]) == 0 {
+		delete(h.subs, gameID)
+	}
+	close(ch)
+}
+
+// publish fans event out to every current subscriber of gameID. A subscriber
+// whose buffer is full is skipped rather than blocking the publisher — the
+// stream is a convenience, callers should still poll if they need a guarantee.
+func (h *gameHub) publish(gameID int, event gameEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[gameID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// gameStreamHandler streams move/game_state/hint_used/chat events for a game
+// to its participants over Server-Sent Events, eliminating the need to poll
+// GET /game/{id} and GET /game/{id}/moves while waiting on an opponent or bot.
+// GET /game/{id}/stream
+func gameStreamHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := claimsFromCtx(r)
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			jsonError(w, "invalid game id", http.StatusBadRequest)
+			return
+		}
+
+		var whiteID, blackID int
+		err = db.QueryRow(`SELECT white_id, black_id FROM games WHERE id = $1`, id).Scan(&whiteID, &blackID)
+		if err == sql.ErrNoRows {
+			jsonError(w, "game not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			jsonError(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if whiteID != claims.UserID && blackID != claims.UserID {
+			jsonError(w, "not a participant", http.StatusForbidden)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			jsonError(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		ch := globalGameHub.subscribe(id)
+		defer globalGameHub.unsubscribe(id, ch)
+
+		for {
+			select {
+			case event, ok := <-ch:
+				if !ok {
+					return
+				}
+				payload, err := json.Marshal(event.Data)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, payload)
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// authResponseLatency is the minimum time loginHandler/registerHandler take
+// to respond, success or failure — without it, a fast rejection (no such
+// user) vs. a slow one (bcrypt actually ran) leaks which branch fired.
+const authResponseLatency = 300 * time.Millisecond
+
+// withConstantLatency wraps an auth handler so every response takes at least
+// authResponseLatency, similar to the misdirection delay in the telebit DNS
+// middleware.
+func withConstantLatency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next(w, r)
+		if remaining := authResponseLatency - time.Since(start); remaining > 0 {
+			time.Sleep(remaining)
+		}
+	}
+}
+
+const (
+	loginThrottleThreshold   = 5 // failures allowed before lockout kicks in
+	loginThrottleBaseBackoff = 2 * time.Second
+	loginThrottleMaxBackoff  = 5 * time.Minute
+)
+
+type throttleRecord struct {
+	failures    int
+	lockedUntil time.Time
+}
+
+// loginThrottle is an in-memory, per-(username, IP) brute-force limiter.
+// Past loginThrottleThreshold consecutive failures it locks the key out for
+// an exponentially growing window, same general shape as a token-bucket
+// limiter but cheaper to reason about for "how many failures before I'm
+// locked out".
+type loginThrottle struct {
+	mu      sync.Mutex
+	records map[string]*throttleRecord
+}
+
+var globalLoginThrottle = &loginThrottle{records: map[string]*throttleRecord{}}
+
+func throttleKey(username, ip string) string {
+	return username + "|" + ip
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// locked reports whether key is still within its lockout window.
+func (t *loginThrottle) locked(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rec, ok := t.records[key]
+	return ok && time.Now().Before(rec.lockedUntil)
+}
+
+// recordFailure bumps key's failure count and, once over the threshold,
+// extends its lockout window exponentially.
+func (t *loginThrottle) recordFailure(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rec, ok := t.records[key]
+	if !ok {
+		rec = &throttleRecord{}
+		t.records[key] = rec
+	}
+	rec.failures++
+
+	if over := rec.failures - loginThrottleThreshold; over > 0 {
+		if over > 8 {
+			over = 8 // cap the exponent so backoff saturates at loginThrottleMaxBackoff
+		}
+		backoff := loginThrottleBaseBackoff * time.Duration(uint(1)<<uint(over))
+		if backoff > loginThrottleMaxBackoff {
+			backoff = loginThrottleMaxBackoff
+		}
+		rec.lockedUntil = time.Now().Add(backoff)
+	}
+}
+
+// reset clears a key's failure history after a successful login.
+func (t *loginThrottle) reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.records, key)
+}
+
+// clearUsername clears every key for username, regardless of which IP it was
+// recorded against — used by adminUnlockHandler.
+func (t *loginThrottle) clearUsername(username string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prefix := username + "|"
+	for key := range t.records {
+		if strings.HasPrefix(key, prefix) {
+			delete(t.records, key)
+		}
+	}
+}
+
+// adminUnlockHandler clears a brute-force lockout for a username, for
+// operators to use when a legitimate user gets caught behind e.g. a shared
+// office IP.
+// POST /admin/unlock  {"username":"alice"}
+func adminUnlockHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Username string `json:"username"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Username == "" {
+			jsonError(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		globalLoginThrottle.clearUsername(body.Username)
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}